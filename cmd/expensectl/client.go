@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+// Client talks to the expense manager JSON API
+type Client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// NewClient creates a client for the configured server
+func NewClient(cfg *Config) *Client {
+	return &Client{
+		server: strings.TrimSuffix(cfg.Server, "/"),
+		token:  cfg.Token,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends a request and decodes a JSON response into out when it is not nil
+func (c *Client) do(method, path string, body io.Reader, contentType string, out interface{}) error {
+	resp, err := c.send(method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send performs a request and turns non-2xx responses into errors
+func (c *Client) send(method, path string, body io.Reader, contentType string) (*http.Response, error) {
+	if c.token == "" {
+		return nil, fmt.Errorf("no API token configured, run 'expensectl login' first")
+	}
+
+	req, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// doJSON sends v as a JSON body
+func (c *Client) doJSON(method, path string, v, out interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.do(method, path, bytes.NewReader(body), "application/json", out)
+}
+
+// ListExpenses returns the expenses of a month (YYYY-MM), or all when empty
+func (c *Client) ListExpenses(month string) ([]models.ExpenseJSON, error) {
+	path := "/api/expenses"
+	if month != "" {
+		path += "?month=" + url.QueryEscape(month)
+	}
+	var expenses []models.ExpenseJSON
+	err := c.do(http.MethodGet, path, nil, "", &expenses)
+	return expenses, err
+}
+
+// GetExpense returns a single expense
+func (c *Client) GetExpense(id int64) (models.ExpenseJSON, error) {
+	var expense models.ExpenseJSON
+	err := c.do(http.MethodGet, fmt.Sprintf("/api/expenses/%d", id), nil, "", &expense)
+	return expense, err
+}
+
+// AddExpense creates an expense and returns it as stored by the server
+func (c *Client) AddExpense(e models.ExpenseJSON) (models.ExpenseJSON, error) {
+	var created models.ExpenseJSON
+	err := c.doJSON(http.MethodPost, "/api/expenses", e, &created)
+	return created, err
+}
+
+// UpdateExpense replaces an expense and returns it as stored by the server
+func (c *Client) UpdateExpense(id int64, e models.ExpenseJSON) (models.ExpenseJSON, error) {
+	var updated models.ExpenseJSON
+	err := c.doJSON(http.MethodPut, fmt.Sprintf("/api/expenses/%d", id), e, &updated)
+	return updated, err
+}
+
+// DeleteExpense removes an expense
+func (c *Client) DeleteExpense(id int64) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/api/expenses/%d", id), nil, "", nil)
+}
+
+// ImportExpenses uploads a CSV or JSON file body
+func (c *Client) ImportExpenses(body io.Reader, contentType string) (models.ImportResult, error) {
+	var result models.ImportResult
+	err := c.do(http.MethodPost, "/api/expenses/import", body, contentType, &result)
+	return result, err
+}
+
+// ExportExpenses copies the export in the given format to w
+func (c *Client) ExportExpenses(format string, w io.Writer) error {
+	resp, err := c.send(http.MethodGet, "/api/expenses/export?format="+url.QueryEscape(format), nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// YearlyReport returns the spending report for a year
+func (c *Client) YearlyReport(year int) (models.YearlyReport, error) {
+	var report models.YearlyReport
+	err := c.do(http.MethodGet, fmt.Sprintf("/api/reports/yearly?year=%d", year), nil, "", &report)
+	return report, err
+}
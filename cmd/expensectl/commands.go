@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+func runLogin(args []string) error {
+	fs := newFlagSet("login")
+	server := fs.String("server", "", "Server base URL, e.g. https://expenses.example.com")
+	token := fs.String("token", "", "Personal access token from the settings page")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		cfg.Server = *server
+	}
+	if *token != "" {
+		cfg.Token = *token
+	}
+
+	// Verify the credentials before storing them
+	if _, err := NewClient(cfg).ListExpenses(time.Now().Format(models.MonthFormat)); err != nil {
+		return err
+	}
+
+	path, err := saveConfig(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Logged in to %s, configuration saved to %s\n", cfg.Server, path)
+	return nil
+}
+
+func runAdd(args []string) error {
+	fs := newFlagSet("add")
+	amount := fs.Float64("amount", 0, "Amount spent")
+	category := fs.String("category", "", "Category: "+strings.Join(models.Categories(), ", "))
+	description := fs.String("description", "", "Description")
+	date := fs.String("date", time.Now().Format(models.DateFormat), "Date (YYYY-MM-DD)")
+	asJSON := fs.Bool("json", false, "Print the created expense as JSON")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *amount == 0 || *category == "" {
+		fs.Usage()
+		return fmt.Errorf("--amount and --category are required")
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	created, err := client.AddExpense(models.ExpenseJSON{
+		Amount:      *amount,
+		Category:    *category,
+		Description: *description,
+		Date:        *date,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(created)
+	}
+	fmt.Printf("Added expense %d\n", created.ID)
+	return nil
+}
+
+func runList(args []string) error {
+	fs := newFlagSet("list")
+	month := fs.String("month", time.Now().Format(models.MonthFormat), "Month (YYYY-MM), or \"all\"")
+	asJSON := fs.Bool("json", false, "Print JSON instead of a table")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *month == "all" {
+		*month = ""
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	expenses, err := client.ListExpenses(*month)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(expenses)
+	}
+	printExpenses(os.Stdout, expenses)
+	return nil
+}
+
+func runEdit(args []string) error {
+	fs := newFlagSet("edit")
+	amount := fs.Float64("amount", 0, "New amount")
+	category := fs.String("category", "", "New category")
+	description := fs.String("description", "", "New description")
+	date := fs.String("date", "", "New date (YYYY-MM-DD)")
+	asJSON := fs.Bool("json", false, "Print the updated expense as JSON")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := expenseIDArg(fs, positional)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	expense, err := client.GetExpense(id)
+	if err != nil {
+		return err
+	}
+
+	// Only overwrite the fields that were given on the command line
+	changed := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "amount":
+			expense.Amount = *amount
+		case "category":
+			expense.Category = *category
+		case "description":
+			expense.Description = *description
+		case "date":
+			expense.Date = *date
+		default:
+			return
+		}
+		changed = true
+	})
+	if !changed {
+		return fmt.Errorf("nothing to change, pass at least one of --amount, --category, --description or --date")
+	}
+
+	updated, err := client.UpdateExpense(id, expense)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(updated)
+	}
+	fmt.Printf("Updated expense %d\n", updated.ID)
+	return nil
+}
+
+func runDelete(args []string) error {
+	fs := newFlagSet("delete")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	id, err := expenseIDArg(fs, positional)
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	if err := client.DeleteExpense(id); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted expense %d\n", id)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := newFlagSet("import")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one file")
+	}
+
+	path := positional[0]
+	var contentType string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		contentType = "text/csv"
+	case ".json":
+		contentType = "application/json"
+	default:
+		return fmt.Errorf("unsupported file type %q, use .csv or .json", filepath.Ext(path))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	result, err := client.ImportExpenses(file, contentType)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d expenses\n", result.Imported)
+	return nil
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export")
+	format := fs.String("format", "csv", "Output format: csv or json")
+	output := fs.String("output", "", "Write to this file instead of standard output")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("unsupported format %q, use csv or json", *format)
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return client.ExportExpenses(*format, w)
+}
+
+func runReport(args []string) error {
+	fs := newFlagSet("report")
+	year := fs.Int("year", time.Now().Year(), "Calendar year")
+	asJSON := fs.Bool("json", false, "Print JSON instead of a table")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	report, err := client.YearlyReport(*year)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(report)
+	}
+	printReport(os.Stdout, report)
+	return nil
+}
+
+// expenseIDArg parses the single expense ID positional argument
+func expenseIDArg(fs *flag.FlagSet, positional []string) (int64, error) {
+	if len(positional) != 1 {
+		fs.Usage()
+		return 0, fmt.Errorf("expected exactly one expense ID")
+	}
+	id, err := strconv.ParseInt(positional[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expense ID %q", positional[0])
+	}
+	return id, nil
+}
+
+// printJSON writes v to standard output as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printExpenses writes expenses as an aligned table followed by the total
+func printExpenses(w io.Writer, expenses []models.ExpenseJSON) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "ID\tDATE\tCATEGORY\tDESCRIPTION\tAMOUNT\t")
+
+	var total float64
+	for _, e := range expenses {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%.2f\t\n", e.ID, e.Date, e.Category, e.Description, e.Amount)
+		total += e.Amount
+	}
+	fmt.Fprintf(tw, "\t\t\tTOTAL\t%.2f\t\n", total)
+	tw.Flush()
+}
+
+// printReport writes a yearly report as two aligned tables
+func printReport(w io.Writer, report models.YearlyReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "MONTH\tTOTAL\t")
+	for _, m := range report.MonthlyTotals {
+		fmt.Fprintf(tw, "%s\t%.2f\t\n", m.Month, m.Total)
+	}
+	fmt.Fprintf(tw, "%d\t%.2f\t\n", report.Year, report.Total)
+	fmt.Fprintf(tw, "AVERAGE\t%.2f\t\n", report.MonthlyAverage)
+	tw.Flush()
+
+	if len(report.CategoryTotals) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "CATEGORY\tTOTAL\t")
+	for _, c := range report.CategoryTotals {
+		fmt.Fprintf(tw, "%s\t%.2f\t\n", c.Category, c.Total)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config holds the server address and token used by the client
+type Config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+// configPath returns the location of the stored client configuration
+func configPath() (string, error) {
+	if path := os.Getenv("EXPENSECTL_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "expensectl", "config.json"), nil
+}
+
+// loadConfig reads the stored configuration and applies environment overrides
+func loadConfig() (*Config, error) {
+	cfg := &Config{Server: "http://localhost:8080"}
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	if server := os.Getenv("EXPENSECTL_SERVER"); server != "" {
+		cfg.Server = server
+	}
+	if token := os.Getenv("EXPENSECTL_TOKEN"); token != "" {
+		cfg.Token = token
+	}
+
+	return cfg, nil
+}
+
+// saveConfig stores the configuration readable only by the current user
+func saveConfig(cfg *Config) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0o600)
+}
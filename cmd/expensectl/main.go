@@ -0,0 +1,113 @@
+// Command expensectl is a command-line client for the expense manager API.
+//
+// It authenticates with a personal access token created on the server's
+// settings page and stored by "expensectl login".
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command is a single expensectl subcommand
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+func commands() []command {
+	return []command{
+		{"login", "login --server URL --token TOKEN", "Store the server address and API token", runLogin},
+		{"add", "add --amount N --category C --description D [--date YYYY-MM-DD]", "Add an expense", runAdd},
+		{"list", "list [--month YYYY-MM] [--json]", "List expenses", runList},
+		{"edit", "edit ID [--amount N] [--category C] [--description D] [--date YYYY-MM-DD]", "Change an expense", runEdit},
+		{"delete", "delete ID", "Delete an expense", runDelete},
+		{"import", "import FILE", "Import expenses from a .csv or .json file", runImport},
+		{"export", "export [--format csv|json] [--output FILE]", "Export all expenses", runExport},
+		{"report", "report [--year YYYY] [--json]", "Show a yearly spending report", runReport},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: expensectl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands() {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'expensectl <command> -h' for command flags.")
+	fmt.Fprintln(os.Stderr, "EXPENSECTL_SERVER and EXPENSECTL_TOKEN override the stored configuration.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	if name == "-h" || name == "--help" || name == "help" {
+		usage()
+		return
+	}
+
+	for _, c := range commands() {
+		if c.name == name {
+			if err := c.run(os.Args[2:]); err != nil {
+				if err == flag.ErrHelp {
+					os.Exit(2)
+				}
+				fmt.Fprintf(os.Stderr, "expensectl %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "expensectl: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// newFlagSet creates a flag set that prints the command's usage line
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, c := range commands() {
+			if c.name == name {
+				fmt.Fprintf(fs.Output(), "Usage: expensectl %s\n", c.usage)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses flags that may appear before or after positional
+// arguments and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// newClient loads the stored configuration and creates an API client
+func newClient() (*Client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(cfg), nil
+}
@@ -0,0 +1,329 @@
+// Command i18ncheck checks the translation files against each other and
+// against the code that uses them. It reports keys one language has and
+// another lacks, plural messages without the forms their language needs, keys
+// the templates or handlers translate that no file defines, and keys nothing
+// uses. It exits with status 1 if it finds any, so it can run in CI:
+//
+//	go run ./cmd/i18ncheck
+//
+// Keys built at runtime, such as printf "categories.%s" in a template or
+// "password.error."+reason in Go, count as using every key with that prefix.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	"expensemanager/internal/i18n"
+)
+
+// reference is a use of a translation key
+type reference struct {
+	key string
+	// The key was built at runtime and key is its constant start
+	prefix bool
+	// File, line and column of the use
+	pos string
+}
+
+func main() {
+	localesDir := flag.String("locales", "", "directory of translation files (default: the built-in ones)")
+	templatesDir := flag.String("templates", "cmd/server/templates", "directory of templates using t and tn")
+	srcDir := flag.String("src", ".", "directory of Go code using the i18n Manager")
+	flag.Parse()
+
+	locales := i18n.Locales()
+	if *localesDir != "" {
+		locales = os.DirFS(*localesDir)
+	}
+	files, err := i18n.ReadLocales(locales)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	templateRefs, err := templateReferences(*templatesDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	goRefs, checked, err := goReferences(*srcDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	langs := make([]string, 0, len(files))
+	keys := make(map[string]bool)
+	for lang, translations := range files {
+		langs = append(langs, lang)
+		for key := range translations {
+			keys[key] = true
+		}
+	}
+	slices.Sort(langs)
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	slices.Sort(sorted)
+
+	problems := 0
+	report := func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+		problems++
+	}
+
+	// Every language should have every key
+	for _, lang := range langs {
+		for _, key := range sorted {
+			if _, ok := files[lang][key]; !ok {
+				report("%s.json: missing %s", lang, key)
+			}
+		}
+	}
+
+	// Plural messages need a form for each category of their language
+	for _, lang := range langs {
+		categories := i18n.PluralCategories(lang)
+		for _, key := range sorted {
+			message := files[lang][key]
+			if message.Forms == nil {
+				continue
+			}
+			for _, category := range categories {
+				if _, ok := message.Forms[category]; !ok {
+					report("%s.json: %s lacks the plural form %s", lang, key, category)
+				}
+			}
+			forms := make([]string, 0, len(message.Forms))
+			for form := range message.Forms {
+				forms = append(forms, form)
+			}
+			slices.Sort(forms)
+			for _, form := range forms {
+				if !slices.Contains(categories, form) && !exactCount(form) {
+					report("%s.json: %s has the plural form %s, which %s does not use", lang, key, form, lang)
+				}
+			}
+		}
+	}
+
+	// Keys the code translates must exist
+	for _, ref := range append(templateRefs, checked...) {
+		if ref.prefix {
+			if !slices.ContainsFunc(sorted, func(key string) bool { return strings.HasPrefix(key, ref.key) }) {
+				report("%s: no keys start with %s", ref.pos, ref.key)
+			}
+		} else if !keys[ref.key] {
+			report("%s: undefined key %s", ref.pos, ref.key)
+		}
+	}
+
+	// Keys should be used somewhere
+	used := make(map[string]bool)
+	var prefixes []string
+	for _, ref := range append(templateRefs, goRefs...) {
+		if ref.prefix {
+			prefixes = append(prefixes, ref.key)
+		} else {
+			used[ref.key] = true
+		}
+	}
+	for _, key := range sorted {
+		if !used[key] && !slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) }) {
+			report("unused key %s", key)
+		}
+	}
+
+	if problems > 0 {
+		fmt.Fprintf(os.Stderr, "%d problems in %s\n", problems, strings.Join(langs, ", "))
+		os.Exit(1)
+	}
+}
+
+// templateReferences finds the keys translated by the t and tn functions in the
+// templates under dir
+func templateReferences(dir string) ([]reference, error) {
+	var refs []reference
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if ext := filepath.Ext(path); ext != ".html" && ext != ".txt" {
+			return nil
+		}
+
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// Function names are not checked, so the template functions of the
+		// server need not be known here
+		tree := parse.New(path)
+		tree.Mode = parse.SkipFuncCheck
+		trees := make(map[string]*parse.Tree)
+		if _, err := tree.Parse(string(text), "", "", trees); err != nil {
+			return err
+		}
+		for _, t := range trees {
+			refs = append(refs, translations(t, t.Root)...)
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// translations finds the calls of t and tn below node: {{t .Lang "key"}} uses
+// a key, {{t .Lang (printf "prefix.%s" .Value)}} every key with the prefix
+func translations(tree *parse.Tree, node parse.Node) []reference {
+	var refs []reference
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			refs = append(refs, translations(tree, child)...)
+		}
+	case *parse.ActionNode:
+		refs = translations(tree, n.Pipe)
+	case *parse.IfNode:
+		refs = branchTranslations(tree, &n.BranchNode)
+	case *parse.RangeNode:
+		refs = branchTranslations(tree, &n.BranchNode)
+	case *parse.WithNode:
+		refs = branchTranslations(tree, &n.BranchNode)
+	case *parse.TemplateNode:
+		refs = translations(tree, n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			refs = append(refs, translations(tree, cmd)...)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) >= 3 && (isIdentifier(n.Args[0], "t") || isIdentifier(n.Args[0], "tn")) {
+			location, _ := tree.ErrorContext(n)
+			if key, prefix, ok := constantKey(n.Args[2]); ok {
+				refs = append(refs, reference{key: key, prefix: prefix, pos: location})
+			}
+		}
+		for _, arg := range n.Args {
+			refs = append(refs, translations(tree, arg)...)
+		}
+	}
+	return refs
+}
+
+func branchTranslations(tree *parse.Tree, n *parse.BranchNode) []reference {
+	refs := translations(tree, n.Pipe)
+	refs = append(refs, translations(tree, n.List)...)
+	return append(refs, translations(tree, n.ElseList)...)
+}
+
+// constantKey returns the key passed to t as a string, or the constant start
+// of one formatted with printf. Keys held in variables are not known.
+func constantKey(arg parse.Node) (key string, prefix, ok bool) {
+	switch n := arg.(type) {
+	case *parse.StringNode:
+		return n.Text, false, true
+	case *parse.PipeNode:
+		if len(n.Cmds) != 1 || len(n.Cmds[0].Args) < 2 || !isIdentifier(n.Cmds[0].Args[0], "printf") {
+			return "", false, false
+		}
+		format, isString := n.Cmds[0].Args[1].(*parse.StringNode)
+		if !isString {
+			return "", false, false
+		}
+		if i := strings.Index(format.Text, "%"); i >= 0 {
+			return format.Text[:i], true, true
+		}
+		return format.Text, false, true
+	}
+	return "", false, false
+}
+
+func isIdentifier(node parse.Node, name string) bool {
+	ident, ok := node.(*parse.IdentifierNode)
+	return ok && ident.Ident == name
+}
+
+// goReferences finds the keys used by the Go code under dir. Any string
+// constant may be a key handed on to be translated later, so all of them are
+// returned as uses; only the keys passed straight to the Manager are returned
+// to be checked, since other strings need not be keys at all.
+func goReferences(dir string) (uses, checked []reference, err error) {
+	fset := token.NewFileSet()
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if name := entry.Name(); path != dir && (strings.HasPrefix(name, ".") || name == "vendor" || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(file, func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.BasicLit:
+				if s, ok := stringConstant(n); ok {
+					uses = append(uses, reference{key: s, pos: fset.Position(n.Pos()).String()})
+				}
+			case *ast.BinaryExpr:
+				// "password.error." + reason
+				if s, ok := stringConstant(n.X); ok && n.Op == token.ADD && strings.HasSuffix(s, ".") {
+					uses = append(uses, reference{key: s, prefix: true, pos: fset.Position(n.Pos()).String()})
+				}
+			case *ast.CallExpr:
+				// manager.Translate(lang, "key"), and likewise Format and Plural
+				if sel, ok := n.Fun.(*ast.SelectorExpr); ok && translateMethods[sel.Sel.Name] && len(n.Args) >= 2 {
+					if s, ok := stringConstant(n.Args[1]); ok {
+						checked = append(checked, reference{key: s, pos: fset.Position(n.Args[1].Pos()).String()})
+					}
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	return uses, checked, err
+}
+
+// translateMethods are the methods of i18n.Manager that take a key after the
+// language
+var translateMethods = map[string]bool{"Translate": true, "Format": true, "Plural": true}
+
+// exactCount reports whether form is an exact-count form such as "=0"
+func exactCount(form string) bool {
+	count, ok := strings.CutPrefix(form, "=")
+	_, err := strconv.ParseFloat(count, 64)
+	return ok && err == nil
+}
+
+func stringConstant(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
@@ -0,0 +1,93 @@
+// Command pwnedprefixes turns a download of Have I Been Pwned's Pwned
+// Passwords (https://haveibeenpwned.com/Passwords), whose lines read
+// "SHA1HASH:COUNT", into a file for BREACHED_PASSWORDS_FILE. It keeps the
+// hash prefixes of the passwords seen most often, so the server holds a few
+// megabytes instead of the whole corpus:
+//
+//	go run ./cmd/pwnedprefixes -top 1000000 < pwnedpasswords.txt > breached.txt
+//
+// The output starts with a comment naming the source; keep it with the data.
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// password is one line of the corpus
+type password struct {
+	prefix string
+	count  uint64
+}
+
+// leastSeen is a min-heap of passwords by count, so the least common of the
+// kept ones is the first to go
+type leastSeen []password
+
+func (h leastSeen) Len() int           { return len(h) }
+func (h leastSeen) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h leastSeen) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *leastSeen) Push(x any)        { *h = append(*h, x.(password)) }
+func (h *leastSeen) Pop() any {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
+
+func main() {
+	top := flag.Int("top", 1000000, "number of most common passwords to keep")
+	flag.Parse()
+	if *top < 1 {
+		log.Fatal("-top must be positive")
+	}
+
+	kept := make(leastSeen, 0, *top)
+	scanner := bufio.NewScanner(os.Stdin)
+	for line := 1; scanner.Scan(); line++ {
+		hash, countText, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || len(hash) != 40 {
+			log.Fatalf("line %d: expected SHA1HASH:COUNT", line)
+		}
+		count, err := strconv.ParseUint(countText, 10, 64)
+		if err != nil {
+			log.Fatalf("line %d: invalid count %q", line, countText)
+		}
+
+		p := password{prefix: strings.ToUpper(hash[:8]), count: count}
+		switch {
+		case len(kept) < *top:
+			heap.Push(&kept, p)
+		case count > kept[0].count:
+			kept[0] = p
+			heap.Fix(&kept, 0)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	prefixes := make([]string, len(kept))
+	for i, p := range kept {
+		prefixes[i] = p.prefix
+	}
+	slices.Sort(prefixes)
+	prefixes = slices.Compact(prefixes)
+
+	out := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(out, "# SHA-1 prefixes of the %d most common passwords in Pwned Passwords\n", len(kept))
+	fmt.Fprintln(out, "# Source: Have I Been Pwned, https://haveibeenpwned.com/Passwords")
+	for _, prefix := range prefixes {
+		fmt.Fprintln(out, prefix)
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
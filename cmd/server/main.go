@@ -1,22 +1,36 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"flag"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"expensemanager/internal/config"
 	"expensemanager/internal/database"
+	"expensemanager/internal/events"
 	"expensemanager/internal/handlers"
 	"expensemanager/internal/i18n"
+	"expensemanager/internal/logging"
+	"expensemanager/internal/mail"
+	"expensemanager/internal/metrics"
 	"expensemanager/internal/middleware"
+	"expensemanager/internal/models"
+	"expensemanager/internal/passkey"
+	"expensemanager/internal/passwords"
+	"expensemanager/internal/sessionstore"
+	"expensemanager/internal/sso"
+	"expensemanager/internal/tracing"
 
 	"github.com/gorilla/sessions"
 	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 //go:embed templates/*
@@ -26,67 +40,94 @@ var templatesFS embed.FS
 var staticFS embed.FS
 
 func main() {
-	// Build PostgreSQL connection string from environment variables
-	dbHost := os.Getenv("DB_HOST")
-	if dbHost == "" {
-		dbHost = "localhost"
-	}
-	dbPort := os.Getenv("DB_PORT")
-	if dbPort == "" {
-		dbPort = "5432"
+	// Settings come from the defaults, a config file, the environment and
+	// flags, in increasing order of precedence
+	printConfig := flag.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fatal("Invalid configuration", err)
 	}
-	dbUser := os.Getenv("DB_USER")
-	if dbUser == "" {
-		dbUser = "postgres"
+	if *printConfig {
+		cfg.Print(os.Stdout)
+		return
 	}
-	dbPassword := os.Getenv("DB_PASSWORD")
-	if dbPassword == "" {
-		dbPassword = "postgres"
+
+	// Log records are structured; those logged while serving a request
+	// carry its request ID and user
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fatal("Invalid logging configuration", err)
 	}
-	dbName := os.Getenv("DB_NAME")
-	if dbName == "" {
-		dbName = "expensemanager"
+	slog.SetDefault(logger)
+
+	// Requests, template renders and queries are traced when a collector is
+	// configured
+	shutdownTracing, err := tracing.Setup(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName,
+		handlers.ReadBuildInfo().Version, cfg.Tracing.SampleRatio)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
 	}
-	dbSSLMode := os.Getenv("DB_SSLMODE")
-	if dbSSLMode == "" {
-		dbSSLMode = "disable"
+
+	if cfg.Session.Key == config.DefaultSessionKey {
+		slog.Warn("Using the default session key; set SESSION_KEY before running in production")
 	}
 
-	// Format PostgreSQL connection string
-	connStr := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		dbHost, dbPort, dbUser, dbPassword, dbName, dbSSLMode,
-	)
+	connStr := cfg.DB.PostgresConnectionString()
 
 	// Initialize database
 	db, err := database.NewDB(connStr)
 	if err != nil {
-		log.Fatal(err)
+		fatal("Failed to connect to the database", err)
 	}
 
 	if err := db.Initialize(); err != nil {
-		log.Fatal(err)
+		fatal("Failed to initialize the database", err)
+	}
+	if err := metrics.Register(collectors.NewDBStatsCollector(db.DB, cfg.DB.DBName)); err != nil {
+		fatal("Failed to register database metrics", err)
 	}
 
-	// Initialize i18n manager
-	log.Printf("Loading i18n translations...")
-	i18nManager := i18n.NewManager("en")
-	if err := i18nManager.LoadTranslations("internal/i18n/locales"); err != nil {
-		log.Fatalf("Failed to load translations: %v", err)
+	// Initialize event bus for live updates. The postgres bus shares events
+	// between instances through LISTEN/NOTIFY; memory is enough for one instance.
+	var bus events.Bus
+	switch cfg.Events.Bus {
+	case "memory":
+		bus = events.NewMemoryBus()
+	case "postgres":
+		bus, err = events.NewPostgresBus(db.DB, connStr)
+		if err != nil {
+			fatal("Failed to start postgres event bus", err)
+		}
 	}
-	log.Printf("Available languages: %v", i18nManager.GetAvailableLanguages())
 
-	// Initialize session store
-	sessionKey := os.Getenv("SESSION_KEY")
-	if sessionKey == "" {
-		sessionKey = "your-secret-key-change-me" // Change this in production
+	// Initialize i18n manager. Translations are built in; LOCALES_DIR can
+	// override them without a rebuild.
+	i18nManager := i18n.NewManager(cfg.I18n.DefaultLanguage)
+	if err := i18nManager.LoadTranslations(i18n.Locales()); err != nil {
+		fatal("Failed to load translations", err)
+	}
+	if cfg.I18n.LocalesDir != "" {
+		slog.Debug("Loading translations", "dir", cfg.I18n.LocalesDir)
+		if err := i18nManager.LoadTranslations(os.DirFS(cfg.I18n.LocalesDir)); err != nil {
+			fatal("Failed to load translations", err)
+		}
 	}
-	store := sessions.NewCookieStore([]byte(sessionKey))
+	slog.Info("Translations loaded", "languages", i18nManager.GetAvailableLanguages())
+
+	// Initialize session store. Sessions are kept in the database and end
+	// after the idle timeout without use or the lifetime after sign-in,
+	// whichever comes first. The cookie is only sent over HTTPS unless the
+	// server is reached over plain HTTP during development.
+	store := sessionstore.NewPostgresStore(db, cfg.Session.IdleTimeout, cfg.Session.Lifetime, []byte(cfg.Session.Key))
 	store.Options = &sessions.Options{
 		Path:     "/",
-		MaxAge:   86400 * 7, // 7 days
+		MaxAge:   int(cfg.Session.Lifetime.Seconds()),
 		HttpOnly: true,
+		Secure:   cfg.IsProduction() || strings.HasPrefix(cfg.HTTP.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
 	}
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	go store.SweepExpired(sweepCtx, sessionstore.SweepInterval)
 
 	// Template functions
 	funcMap := template.FuncMap{
@@ -124,41 +165,156 @@ func main() {
 		"formatDate": func(t time.Time) string {
 			return t.Format("2006-01-02")
 		},
+		// Sizes such as "1.5 MB"
+		"formatBytes": func(n int64) string {
+			const unit = 1024
+			if n < unit {
+				return fmt.Sprintf("%d B", n)
+			}
+			div, exp := int64(unit), 0
+			for m := n / unit; m >= unit; m /= unit {
+				div *= unit
+				exp++
+			}
+			return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+		},
 		// Money formatting
 		"formatMoney": func(amount float64) string {
 			return fmt.Sprintf("$%.2f", amount)
 		},
-		// Translation function
-		"t": func(lang, key string) string {
-			return i18nManager.Translate(lang, key)
+		// Translation functions. Named parameters follow the key in pairs:
+		// {{t .Lang "key" "amount" .Amount}}, {{tn .Lang "key" .Count}}
+		"t": func(lang, key string, args ...any) (string, error) {
+			params, err := i18n.Pairs(args...)
+			if err != nil {
+				return "", err
+			}
+			return i18nManager.Format(lang, key, params), nil
+		},
+		"tn": func(lang, key string, count any, args ...any) (string, error) {
+			params, err := i18n.Pairs(args...)
+			if err != nil {
+				return "", err
+			}
+			return i18nManager.Plural(lang, key, count, params), nil
 		},
 		// String manipulation
 		"lower": strings.ToLower,
 	}
 
 	// Parse templates with functions
-	log.Printf("Loading templates from embedded filesystem...")
+	slog.Debug("Loading templates from embedded filesystem")
 	templates, err := templatesFS.ReadDir("templates")
 	if err != nil {
-		log.Fatalf("Failed to read templates directory: %v", err)
+		fatal("Failed to read templates directory", err)
 	}
 	for _, template := range templates {
-		log.Printf("Found template: %s", template.Name())
+		slog.Debug("Found template", "name", template.Name())
 	}
 
 	tmpl, err := template.New("").Funcs(funcMap).ParseFS(templatesFS, "templates/*.html")
 	if err != nil {
-		log.Fatalf("Failed to parse templates: %v", err)
+		fatal("Failed to parse templates", err)
+	}
+	slog.Debug("Templates loaded")
+
+	// Email templates are plain text and share the translation function
+	mailTemplates, err := mail.ParseTemplates(templatesFS, "templates/email/*.txt", map[string]interface{}{
+		"t":  funcMap["t"],
+		"tn": funcMap["tn"],
+	})
+	if err != nil {
+		fatal("Failed to parse email templates", err)
 	}
-	log.Printf("Templates loaded successfully")
+
+	// Initialize mailer. The log mailer prints messages (and optionally saves
+	// them to a directory) for development; smtp delivers them for real.
+	var mailer mail.Mailer
+	switch cfg.Mail.Mailer {
+	case "log":
+		mailer, err = mail.NewLogMailer(cfg.Mail.Dir, cfg.Mail.From)
+	case "smtp":
+		mailer, err = mail.NewSMTPMailer(cfg.Mail.SMTPHost, cfg.Mail.SMTPPort,
+			cfg.Mail.SMTPUsername, cfg.Mail.SMTPPassword, cfg.Mail.From)
+	}
+	if err != nil {
+		fatal("Failed to initialize mailer", err)
+	}
+
+	// Links in emails point at the public address of the server
+	baseURL := cfg.HTTP.BaseURL
 
 	// Initialize handlers
 	h := handlers.NewHandler(db, tmpl, store)
 	h.UpdateI18n(i18nManager)
+	h.UpdateEventBus(bus)
 
 	// Initialize auth handler
 	authHandler := handlers.NewAuthHandler(db, tmpl, store)
 	authHandler.UpdateI18n(i18nManager)
+	authHandler.UpdateMailer(mailer, mailTemplates, baseURL)
+
+	// ADMIN_EMAILS bootstraps administrators: listed accounts are promoted at
+	// startup, or as soon as they verify their address
+	adminEmails := cfg.Auth.AdminEmails
+	authHandler.UpdateAdminEmails(adminEmails)
+	if promoted, err := db.PromoteAdmins(adminEmails); err != nil {
+		fatal("Failed to promote administrators", err)
+	} else if promoted > 0 {
+		slog.Info("Promoted accounts listed in ADMIN_EMAILS to administrator", "count", promoted)
+	}
+	if admins, err := db.CountAdmins(); err == nil && admins == 0 {
+		slog.Warn("No administrator yet; set ADMIN_EMAILS to the address of a verified account")
+	}
+
+	// Passkeys are bound to the host name in BASE_URL, so it must match the
+	// address users open in their browser
+	relyingParty, err := passkey.New(baseURL)
+	if err != nil {
+		fatal("Failed to configure passkeys", err)
+	}
+	authHandler.UpdateWebAuthn(relyingParty)
+
+	// UNVERIFIED_POLICY decides what accounts with an unverified email may do
+	authHandler.UpdateUnverifiedPolicy(cfg.Auth.UnverifiedPolicy)
+
+	// TRUST_PROXY takes client addresses for login rate limiting from
+	// X-Forwarded-For; only set it behind a reverse proxy that sets the header
+	authHandler.UpdateTrustProxy(cfg.HTTP.TrustProxy)
+	store.ClientIP = authHandler.ClientIP
+
+	// New passwords must be PASSWORD_MIN_LENGTH characters long and not be a
+	// common password. BREACHED_PASSWORDS_FILE names a breach corpus, such
+	// as the most frequent SHA-1 hashes from Have I Been Pwned, to refuse too;
+	// no breach data is bundled, so without it the check is off.
+	passwordPolicy, err := passwords.NewPolicy(cfg.Auth.PasswordMinLength)
+	if err != nil {
+		fatal("Invalid PASSWORD_MIN_LENGTH", err)
+	}
+	if path := cfg.Auth.BreachedPasswordsFile; path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			fatal("Failed to open BREACHED_PASSWORDS_FILE", err)
+		}
+		err = passwordPolicy.LoadBreached(f)
+		f.Close()
+		if err != nil {
+			fatal("Failed to load BREACHED_PASSWORDS_FILE", err)
+		}
+	} else {
+		slog.Warn("No BREACHED_PASSWORDS_FILE set; new passwords are not checked against breaches")
+	}
+	h.UpdatePasswordPolicy(passwordPolicy)
+	authHandler.UpdatePasswordPolicy(passwordPolicy)
+
+	// OpenID Connect identity providers users can sign in with
+	var ssoProviders []*sso.Provider
+	for _, providerConfig := range cfg.OIDC {
+		ssoProviders = append(ssoProviders, sso.New(providerConfig, baseURL))
+		slog.Info("Sign-in with identity provider enabled", "provider", providerConfig.ID, "issuer", providerConfig.Issuer)
+	}
+	h.UpdateSSOProviders(ssoProviders)
+	authHandler.UpdateSSOProviders(ssoProviders)
 
 	// Create a new mux for routing
 	mux := http.NewServeMux()
@@ -166,10 +322,26 @@ func main() {
 	// Serve static files
 	mux.Handle("/static/", http.FileServer(http.FS(staticFS)))
 
+	// The service worker must be served from the root to control every page
+	mux.HandleFunc("/sw.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFileFS(w, r, staticFS, "static/sw.js")
+	})
+
 	// Auth routes
 	mux.HandleFunc("/login", authHandler.HandleLogin)
 	mux.HandleFunc("/register", authHandler.HandleRegister)
+	mux.HandleFunc("/login/2fa", authHandler.HandleLoginSecondFactor)
+	mux.HandleFunc("/login/passkey/begin", authHandler.HandlePasskeyLoginBegin)
+	mux.HandleFunc("/login/passkey/finish", authHandler.HandlePasskeyLoginFinish)
+	mux.HandleFunc("/login/sso/{provider}", authHandler.HandleSSOLogin)
+	mux.HandleFunc("/login/sso/{provider}/callback", authHandler.HandleSSOCallback)
 	mux.HandleFunc("/logout", authHandler.HandleLogout)
+	mux.HandleFunc("/forgot-password", authHandler.HandleForgotPassword)
+	mux.HandleFunc("/reset-password", authHandler.HandleResetPassword)
+	mux.HandleFunc("/verify-email", authHandler.HandleVerifyEmail)
+	mux.HandleFunc("/verify-email/resend", authHandler.RequireAuthAllowUnverified(authHandler.HandleResendVerification))
+	mux.HandleFunc("/confirm-email", authHandler.HandleConfirmEmail)
 
 	// Protected routes
 	mux.HandleFunc("/", authHandler.RequireAuth(h.HandleIndex))
@@ -177,30 +349,109 @@ func main() {
 	mux.HandleFunc("/expenses/add", authHandler.RequireAuth(h.HandleAddExpense))
 	mux.HandleFunc("/expenses/delete", authHandler.RequireAuth(h.HandleDeleteExpense))
 	mux.HandleFunc("/summary", authHandler.RequireAuth(h.HandleSummary))
+	mux.HandleFunc("/events", authHandler.RequireAuth(h.HandleEvents))
 	mux.HandleFunc("/reports", authHandler.RequireAuth(h.HandleReports))
-	mux.HandleFunc("/api/monthly-totals", authHandler.RequireAuth(h.HandleMonthlyTotals))
-	mux.HandleFunc("/api/category-totals", authHandler.RequireAuth(h.HandleCategoryTotals))
-	mux.HandleFunc("/admin", authHandler.RequireAuth(h.HandleAdmin))
-	mux.HandleFunc("/admin/clear-expenses", authHandler.RequireAuth(h.HandleClearExpenses))
-	mux.HandleFunc("/admin/download-expenses", authHandler.RequireAuth(h.HandleDownloadExpenses))
-	mux.HandleFunc("/admin/upload-expenses", authHandler.RequireAuth(h.HandleUploadExpenses))
+	mux.HandleFunc("/data", authHandler.RequireAuth(h.HandleData))
+	mux.HandleFunc("/data/clear-expenses", authHandler.RequireAuth(h.HandleClearExpenses))
+	mux.HandleFunc("/data/download-expenses", authHandler.RequireAuth(h.HandleDownloadExpenses))
+	mux.HandleFunc("/data/upload-expenses", authHandler.RequireAuth(h.HandleUploadExpenses))
+	mux.HandleFunc("/settings", authHandler.RequireAuth(h.HandleSettings))
+	mux.HandleFunc("/settings/profile", authHandler.RequireAuth(authHandler.HandleUpdateProfile))
+	mux.HandleFunc("/settings/email", authHandler.RequireAuthAllowUnverified(authHandler.HandleChangeEmail))
+	mux.HandleFunc("/settings/password", authHandler.RequireAuthAllowUnverified(authHandler.HandleChangePassword))
+	mux.HandleFunc("/settings/delete-account", authHandler.RequireAuthAllowUnverified(authHandler.HandleDeleteAccount))
+	mux.HandleFunc("/settings/export", authHandler.RequireAuthAllowUnverified(h.HandleExportAccount))
+	mux.HandleFunc("/settings/tokens", authHandler.RequireAuth(h.HandleCreateAPIToken))
+	mux.HandleFunc("/settings/tokens/revoke", authHandler.RequireAuth(h.HandleRevokeAPIToken))
+	mux.HandleFunc("/settings/2fa/setup", authHandler.RequireAuth(h.HandleTOTPSetup))
+	mux.HandleFunc("/settings/2fa/qr", authHandler.RequireAuth(h.HandleTOTPQRCode))
+	mux.HandleFunc("/settings/2fa/enable", authHandler.RequireAuth(h.HandleTOTPEnable))
+	mux.HandleFunc("/settings/2fa/disable", authHandler.RequireAuth(h.HandleTOTPDisable))
+	mux.HandleFunc("/settings/2fa/recovery-codes", authHandler.RequireAuth(h.HandleRegenerateRecoveryCodes))
+	mux.HandleFunc("/settings/passkeys/register/begin", authHandler.RequireAuth(authHandler.HandlePasskeyRegisterBegin))
+	mux.HandleFunc("/settings/passkeys/register/finish", authHandler.RequireAuth(authHandler.HandlePasskeyRegisterFinish))
+	mux.HandleFunc("/settings/passkeys/delete", authHandler.RequireAuth(h.HandleDeletePasskey))
+	mux.HandleFunc("/settings/identities/delete", authHandler.RequireAuth(h.HandleDeleteIdentity))
+	mux.HandleFunc("/settings/sessions/revoke", authHandler.RequireAuthAllowUnverified(h.HandleRevokeSession))
+	mux.HandleFunc("/settings/sessions/revoke-all", authHandler.RequireAuthAllowUnverified(h.HandleRevokeAllSessions))
+
+	// Instance administration
+	mux.HandleFunc("/admin", authHandler.RequireRole(models.RoleAdmin, h.HandleAdmin))
+	mux.HandleFunc("/admin/audit", authHandler.RequireRole(models.RoleAdmin, h.HandleAdminAudit))
+	mux.HandleFunc("/admin/users/{id}/disable", authHandler.RequireRole(models.RoleAdmin, h.HandleAdminDisableUser))
+	mux.HandleFunc("/admin/users/{id}/enable", authHandler.RequireRole(models.RoleAdmin, h.HandleAdminEnableUser))
+	mux.HandleFunc("/admin/users/{id}/force-reset", authHandler.RequireRole(models.RoleAdmin, authHandler.HandleAdminForcePasswordReset))
+	mux.HandleFunc("/admin/users/{id}/reset-2fa", authHandler.RequireRole(models.RoleAdmin, h.HandleAdminResetTOTP))
+	mux.HandleFunc("/admin/users/{id}/delete", authHandler.RequireRole(models.RoleAdmin, h.HandleAdminDeleteUser))
 
 	// Language route
 	mux.HandleFunc("/language", authHandler.HandleLanguage)
 
+	// API routes come from one table so they can be checked against the
+	// OpenAPI spec, here and in the handlers' tests
+	spec := handlers.OpenAPISpec()
+	apiRoutes := handlers.APIRoutes(spec, h, authHandler)
+	for _, route := range apiRoutes {
+		mux.HandleFunc(route.Pattern, route.Handler)
+	}
+	mux.HandleFunc("/api/", handlers.HandleAPIFallback(spec))
+	if err := spec.VerifyRoutes(handlers.Patterns(apiRoutes)); err != nil {
+		fatal("API routes do not match the OpenAPI document", err)
+	}
+
 	// Wrap the mux with middleware
-	handler := middleware.Chain(mux,
+	handler := middleware.Chain(middleware.Route(mux),
+		middleware.RequestID,
+		middleware.Tracing,
+		middleware.Metrics,
 		middleware.Logger,
 		middleware.WithSessionStore(store),
 		middleware.I18n(i18nManager),
+		middleware.CSRF(i18nManager),
 		middleware.Recovery,
 	)
 
+	// Probes and metrics bypass the middleware: they need no session, and
+	// they would drown out real requests in the log
+	root := http.NewServeMux()
+	root.HandleFunc("/healthz", handlers.HandleHealthz)
+	root.HandleFunc("/readyz", h.HandleReadyz)
+	root.HandleFunc("/version", handlers.HandleVersion)
+	root.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
+	root.Handle("/", handler)
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.HTTP.Port,
+		Handler:           root,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+	// Event streams never finish on their own; closing the bus ends them so
+	// shutdown only waits for ordinary requests. The session sweep stops too.
+	srv.RegisterOnShutdown(func() {
+		bus.Close()
+		stopSweep()
+	})
+
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	slog.Info("Server starting", "version", handlers.ReadBuildInfo().Version, "port", cfg.HTTP.Port, "env", cfg.Env)
+	err = serve(srv, cfg.HTTP.ShutdownTimeout, authHandler.Drain)
+	db.Close()
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	cancel()
+	if err != nil {
+		fatal("Server stopped with an error", err)
 	}
-	log.Printf("Server starting on port %s...\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, handler))
+	slog.Info("Server stopped")
+}
+
+// fatal logs an error that keeps the server from running and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
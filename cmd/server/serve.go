@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve runs srv until it receives SIGINT or SIGTERM. It then stops accepting
+// connections and waits up to timeout for in-flight requests to finish and
+// for each drain function, called in order, to return. Connections still open
+// after the timeout are closed. A second signal stops the process at once.
+func serve(srv *http.Server, timeout time.Duration, drain ...func(context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down, waiting for requests to finish", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("requests did not finish in time: %w", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	for _, fn := range drain {
+		if err := fn(shutdownCtx); err != nil {
+			return fmt.Errorf("background work did not finish in time: %w", err)
+		}
+	}
+	return nil
+}
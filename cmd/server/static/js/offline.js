@@ -0,0 +1,162 @@
+// Offline support: registers the service worker and queues expenses added
+// while offline in IndexedDB, replaying them through /api/sync once the
+// connection returns. Every queued expense carries a client-generated UUID,
+// so a batch that is sent twice is only applied once.
+(function () {
+    const DB_NAME = 'expensemanager';
+    const STORE = 'sync-queue';
+    const SYNC_INTERVAL = 30000;
+
+    if ('serviceWorker' in navigator) {
+        navigator.serviceWorker.register('/sw.js').catch(err => console.error('Service worker registration failed:', err));
+    }
+
+    function openDB() {
+        return new Promise((resolve, reject) => {
+            const req = indexedDB.open(DB_NAME, 1);
+            req.onupgradeneeded = () => req.result.createObjectStore(STORE, { keyPath: 'client_id' });
+            req.onsuccess = () => resolve(req.result);
+            req.onerror = () => reject(req.error);
+        });
+    }
+
+    async function withStore(mode, fn) {
+        const db = await openDB();
+        return new Promise((resolve, reject) => {
+            const tx = db.transaction(STORE, mode);
+            const request = fn(tx.objectStore(STORE));
+            tx.oncomplete = () => resolve(request ? request.result : undefined);
+            tx.onerror = () => reject(tx.error);
+        });
+    }
+
+    const queue = {
+        add: op => withStore('readwrite', store => store.put(op)),
+        all: () => withStore('readonly', store => store.getAll()),
+        remove: ids => withStore('readwrite', store => ids.forEach(id => store.delete(id))),
+    };
+
+    function newUUID() {
+        if (crypto.randomUUID) {
+            return crypto.randomUUID();
+        }
+        const b = crypto.getRandomValues(new Uint8Array(16));
+        b[6] = (b[6] & 0x0f) | 0x40;
+        b[8] = (b[8] & 0x3f) | 0x80;
+        const h = Array.from(b, x => x.toString(16).padStart(2, '0')).join('');
+        return `${h.slice(0, 8)}-${h.slice(8, 12)}-${h.slice(12, 16)}-${h.slice(16, 20)}-${h.slice(20)}`;
+    }
+
+    async function updateStatus() {
+        const status = document.getElementById('offline-status');
+        if (!status) {
+            return;
+        }
+        const pending = (await queue.all()).length;
+        if (pending === 0 && navigator.onLine) {
+            status.classList.add('hidden');
+            return;
+        }
+        status.textContent = pending > 0
+            ? status.dataset.queuedMessage.replace('{count}', pending)
+            : status.dataset.offlineMessage;
+        status.classList.remove('hidden');
+    }
+
+    // Queue the add-expense form instead of posting it
+    async function queueForm(form) {
+        const data = new FormData(form);
+        await queue.add({
+            client_id: newUUID(),
+            action: 'upsert',
+            expense: {
+                amount: parseFloat(data.get('amount')),
+                category: data.get('category'),
+                description: data.get('description'),
+                date: data.get('date'),
+            },
+        });
+        form.reset();
+        if (form.querySelector('[name=date]')) {
+            form.querySelector('[name=date]').value = new Date().toISOString().split('T')[0];
+        }
+        await updateStatus();
+    }
+
+    function isAddExpenseForm(elt) {
+        return elt && elt.tagName === 'FORM' && elt.getAttribute('hx-post') === '/expenses/add';
+    }
+
+    document.addEventListener('htmx:beforeRequest', evt => {
+        if (!navigator.onLine && isAddExpenseForm(evt.detail.elt)) {
+            evt.preventDefault();
+            queueForm(evt.detail.elt);
+        }
+    });
+
+    // The browser may think it is online while requests still fail
+    document.addEventListener('htmx:sendError', evt => {
+        if (isAddExpenseForm(evt.detail.elt)) {
+            queueForm(evt.detail.elt);
+        }
+    });
+
+    // The session's CSRF token, rendered into the page by the server
+    function csrfToken() {
+        const meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.content : '';
+    }
+
+    let syncing = false;
+
+    async function sync() {
+        if (syncing || !navigator.onLine) {
+            return;
+        }
+        syncing = true;
+        try {
+            const operations = await queue.all();
+            if (operations.length === 0) {
+                return;
+            }
+
+            const response = await fetch('/api/sync', {
+                method: 'POST',
+                credentials: 'same-origin',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'X-CSRF-Token': csrfToken(),
+                },
+                body: JSON.stringify({ operations }),
+            });
+            if (!response.ok) {
+                return;
+            }
+
+            // Conflicts and invalid operations cannot succeed on retry, so
+            // every answered operation leaves the queue
+            const { results } = await response.json();
+            results.filter(r => r.status !== 'applied').forEach(r => console.warn('Sync', r.status, r.client_id, r.error || ''));
+            await queue.remove(results.map(r => r.client_id));
+
+            if (window.htmx && document.getElementById('selected-month')) {
+                const month = document.getElementById('selected-month').value;
+                htmx.trigger('#selected-month', 'change');
+                htmx.ajax('GET', `/summary?selected-month=${month}`, '#summary-cards');
+            }
+        } catch (err) {
+            console.error('Sync failed:', err);
+        } finally {
+            syncing = false;
+            updateStatus();
+        }
+    }
+
+    window.addEventListener('online', sync);
+    window.addEventListener('offline', updateStatus);
+    document.addEventListener('DOMContentLoaded', () => {
+        updateStatus();
+        sync();
+    });
+    setInterval(sync, SYNC_INTERVAL);
+})();
@@ -0,0 +1,117 @@
+// Passkey registration and sign-in. The server sends WebAuthn options as JSON
+// with binary fields in base64url; they are converted to ArrayBuffers for the
+// browser API, and the authenticator's response is converted back.
+(function () {
+    const supported = !!(window.PublicKeyCredential && navigator.credentials);
+
+    function toBuffer(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        const binary = atob(base64.padEnd(base64.length + (4 - base64.length % 4) % 4, '='));
+        return Uint8Array.from(binary, c => c.charCodeAt(0)).buffer;
+    }
+
+    function toBase64url(buffer) {
+        const binary = String.fromCharCode(...new Uint8Array(buffer));
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function csrfToken() {
+        const meta = document.querySelector('meta[name="csrf-token"]');
+        return meta ? meta.content : '';
+    }
+
+    async function post(url, body) {
+        const response = await fetch(url, {
+            method: 'POST',
+            credentials: 'same-origin',
+            headers: { 'Content-Type': 'application/json', 'X-CSRF-Token': csrfToken() },
+            body: body ? JSON.stringify(body) : undefined,
+        });
+        const data = await response.json().catch(() => ({}));
+        if (!response.ok) {
+            throw new Error(data.message || response.statusText);
+        }
+        return data;
+    }
+
+    function credentialJSON(credential) {
+        const response = credential.response;
+        const json = {
+            id: credential.id,
+            rawId: toBase64url(credential.rawId),
+            type: credential.type,
+            authenticatorAttachment: credential.authenticatorAttachment || undefined,
+            clientExtensionResults: credential.getClientExtensionResults(),
+            response: { clientDataJSON: toBase64url(response.clientDataJSON) },
+        };
+        if (response.attestationObject) {
+            json.response.attestationObject = toBase64url(response.attestationObject);
+            json.response.transports = response.getTransports ? response.getTransports() : [];
+        } else {
+            json.response.authenticatorData = toBase64url(response.authenticatorData);
+            json.response.signature = toBase64url(response.signature);
+            json.response.userHandle = response.userHandle ? toBase64url(response.userHandle) : undefined;
+        }
+        return json;
+    }
+
+    async function register(name) {
+        const { publicKey } = await post('/settings/passkeys/register/begin');
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        publicKey.user.id = toBuffer(publicKey.user.id);
+        (publicKey.excludeCredentials || []).forEach(c => { c.id = toBuffer(c.id); });
+
+        const credential = await navigator.credentials.create({ publicKey });
+        return post('/settings/passkeys/register/finish?name=' + encodeURIComponent(name), credentialJSON(credential));
+    }
+
+    async function login(language) {
+        const { publicKey } = await post('/login/passkey/begin');
+        publicKey.challenge = toBuffer(publicKey.challenge);
+        (publicKey.allowCredentials || []).forEach(c => { c.id = toBuffer(c.id); });
+
+        const credential = await navigator.credentials.get({ publicKey });
+        return post('/login/passkey/finish?language=' + encodeURIComponent(language), credentialJSON(credential));
+    }
+
+    function showError(element, err) {
+        // Cancelling the browser prompt is not an error worth showing
+        if (err.name === 'NotAllowedError' || err.name === 'AbortError') {
+            return;
+        }
+        element.textContent = err.message;
+        element.classList.remove('hidden');
+    }
+
+    document.addEventListener('DOMContentLoaded', () => {
+        document.querySelectorAll('[data-passkey]').forEach(el => {
+            el.classList.toggle('hidden', !supported);
+        });
+        if (!supported) {
+            return;
+        }
+
+        const loginButton = document.getElementById('passkey-login');
+        if (loginButton) {
+            const error = document.getElementById('passkey-error');
+            loginButton.addEventListener('click', () => {
+                error.classList.add('hidden');
+                login(loginButton.dataset.language)
+                    .then(() => { window.location.href = '/'; })
+                    .catch(err => showError(error, err));
+            });
+        }
+
+        const registerForm = document.getElementById('passkey-register');
+        if (registerForm) {
+            const error = document.getElementById('passkey-error');
+            registerForm.addEventListener('submit', event => {
+                event.preventDefault();
+                error.classList.add('hidden');
+                register(registerForm.elements.name.value)
+                    .then(() => { window.location.reload(); })
+                    .catch(err => showError(error, err));
+            });
+        }
+    });
+})();
@@ -0,0 +1,103 @@
+// Service worker: keeps the app shell available offline.
+// Only the pages and HTMX fragments listed in PAGES are cached: they are
+// fetched network-first and fall back to the last cached copy. Static assets
+// are served cache-first. Anything else, such as exports, 2FA QR codes or the
+// admin pages, is never stored on the device.
+// Bumping the version drops caches written by earlier versions.
+const CACHE = 'expensemanager-v2';
+
+const PAGES = new Set(['/', '/expenses', '/summary', '/reports']);
+
+const SHELL = [
+    '/',
+    '/static/css/styles.css',
+    '/static/js/offline.js',
+    '/static/manifest.json',
+    '/static/icons/icon-192.png',
+];
+
+self.addEventListener('install', event => {
+    event.waitUntil(
+        caches.open(CACHE)
+            .then(cache => cache.addAll(SHELL))
+            .then(() => self.skipWaiting())
+    );
+});
+
+self.addEventListener('activate', event => {
+    event.waitUntil(
+        caches.keys()
+            .then(keys => Promise.all(keys.filter(key => key !== CACHE).map(key => caches.delete(key))))
+            .then(() => self.clients.claim())
+    );
+});
+
+self.addEventListener('fetch', event => {
+    const request = event.request;
+    if (request.method !== 'GET') {
+        return;
+    }
+
+    const url = new URL(request.url);
+
+    // Drop cached pages on logout so the next user cannot see them offline
+    if (url.origin === location.origin && url.pathname === '/logout') {
+        event.waitUntil(caches.delete(CACHE));
+        return;
+    }
+
+    if (url.origin === location.origin) {
+        if (url.pathname.startsWith('/static/')) {
+            event.respondWith(cacheFirst(request));
+        } else if (PAGES.has(url.pathname)) {
+            event.respondWith(networkFirst(request));
+        }
+        return;
+    }
+
+    // Scripts, styles and fonts loaded from CDNs are public
+    if (['script', 'style', 'font'].includes(request.destination)) {
+        event.respondWith(cacheFirst(request));
+    }
+});
+
+// storable reports whether a response may be kept in the cache. Responses the
+// server marks no-store hold data that must not outlive the page.
+function storable(response) {
+    if (response.type === 'opaque') {
+        return true;
+    }
+    const cacheControl = response.headers.get('Cache-Control') || '';
+    return response.ok && !/\bno-store\b/i.test(cacheControl);
+}
+
+async function cacheFirst(request) {
+    const cached = await caches.match(request);
+    if (cached) {
+        return cached;
+    }
+    const response = await fetch(request);
+    if (storable(response)) {
+        const cache = await caches.open(CACHE);
+        cache.put(request, response.clone());
+    }
+    return response;
+}
+
+async function networkFirst(request) {
+    try {
+        const response = await fetch(request);
+        // Only cache real pages, not redirects to the login form
+        if (storable(response) && !response.redirected) {
+            const cache = await caches.open(CACHE);
+            cache.put(request, response.clone());
+        }
+        return response;
+    } catch (err) {
+        const cached = await caches.match(request, { ignoreSearch: request.mode === 'navigate' });
+        if (cached) {
+            return cached;
+        }
+        throw err;
+    }
+}
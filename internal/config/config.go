@@ -0,0 +1,561 @@
+// Package config loads the server's configuration. Every setting has a
+// default and can be set in a YAML file, in an environment variable or with a
+// command-line flag; later sources take precedence:
+//
+//	defaults < config file < environment < flags
+//
+// The file is named with the -config flag or the CONFIG_FILE variable. Keys
+// are the setting names, either nested (http: {port: 8080}) or dotted
+// (http.port: 8080), which are also the flag names (-http.port=8080).
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"expensemanager/internal/logging"
+	"expensemanager/internal/models"
+	"expensemanager/internal/passwords"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environments the server runs in. Production refuses settings that are only
+// safe for development, such as the default session key.
+const (
+	Development = "development"
+	Production  = "production"
+)
+
+// DefaultSessionKey signs session cookies when no key is configured. It is
+// public, so production refuses it.
+const DefaultSessionKey = "your-secret-key-change-me"
+
+// Config holds the configuration of the server
+type Config struct {
+	Env     string
+	Log     LogConfig
+	HTTP    HTTPConfig
+	DB      DBConfig
+	Events  EventsConfig
+	Session SessionConfig
+	I18n    I18nConfig
+	Mail    MailConfig
+	Auth    AuthConfig
+	Metrics MetricsConfig
+	Tracing TracingConfig
+	OIDC    []OIDCProviderConfig
+}
+
+// LogConfig holds the logging configuration
+type LogConfig struct {
+	// "debug", "info", "warn" or "error"
+	Level string
+	// "json" for log collectors or "text" for reading in a terminal
+	Format string
+}
+
+// HTTPConfig holds the HTTP server configuration
+type HTTPConfig struct {
+	Port string
+	// Public address of the server, used in links in emails, for passkeys
+	// and for single sign-on redirects
+	BaseURL string
+	// Whether X-Forwarded-For from a reverse proxy names the client
+	TrustProxy bool
+
+	// Limits on slow clients. ReadTimeout covers the whole request
+	// including uploads, WriteTimeout the response; event streams lift both.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// How long a stopping server waits for requests and background work
+	ShutdownTimeout time.Duration
+}
+
+// EventsConfig holds the live update configuration
+type EventsConfig struct {
+	// "memory" for a single instance or "postgres" to share events between
+	// instances with LISTEN/NOTIFY
+	Bus string
+}
+
+// SessionConfig holds the browser session configuration
+type SessionConfig struct {
+	Key         string
+	IdleTimeout time.Duration
+	Lifetime    time.Duration
+}
+
+// I18nConfig holds the translation configuration
+type I18nConfig struct {
+	// Directory of translation files overriding the built-in ones; optional
+	LocalesDir      string
+	DefaultLanguage string
+}
+
+// MailConfig holds the outgoing email configuration
+type MailConfig struct {
+	// "log" prints messages (and saves them to Dir if set); "smtp" sends them
+	Mailer       string
+	From         string
+	Dir          string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// AuthConfig holds the account and sign-in configuration
+type AuthConfig struct {
+	// Addresses promoted to administrator once verified
+	AdminEmails []string
+	// What accounts with an unverified email may do
+	UnverifiedPolicy      string
+	PasswordMinLength     int
+	BreachedPasswordsFile string
+}
+
+// MetricsConfig holds the Prometheus metrics configuration
+type MetricsConfig struct {
+	// Bearer token scrapers must send; empty leaves /metrics open
+	Token string
+}
+
+// TracingConfig holds the OpenTelemetry tracing configuration
+type TracingConfig struct {
+	// OTLP/HTTP collector address; empty turns tracing off
+	Endpoint    string
+	ServiceName string
+	// Share of new traces recorded, from 0 to 1
+	SampleRatio float64
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Env: Development,
+		Log: LogConfig{Level: "info", Format: logging.FormatJSON},
+		HTTP: HTTPConfig{
+			Port:              "8080",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       time.Minute,
+			WriteTimeout:      time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		DB: DBConfig{
+			Host:     "localhost",
+			Port:     "5432",
+			User:     "postgres",
+			Password: "postgres",
+			DBName:   "expensemanager",
+			SSLMode:  "disable",
+		},
+		Events: EventsConfig{Bus: "memory"},
+		Session: SessionConfig{
+			Key:         DefaultSessionKey,
+			IdleTimeout: 24 * time.Hour,
+			Lifetime:    7 * 24 * time.Hour,
+		},
+		I18n: I18nConfig{DefaultLanguage: "en"},
+		Mail: MailConfig{
+			Mailer:   "log",
+			From:     "Expense Manager <no-reply@localhost>",
+			SMTPPort: "587",
+		},
+		Auth: AuthConfig{
+			UnverifiedPolicy:  models.UnverifiedReadOnly,
+			PasswordMinLength: 8,
+		},
+		Tracing: TracingConfig{ServiceName: "expensemanager", SampleRatio: 1},
+	}
+}
+
+// setting is one configuration value with the names it is set by in each source
+type setting struct {
+	key    string // config file key and flag name
+	env    string
+	usage  string
+	secret bool
+	value  flag.Value
+}
+
+// settings lists every setting of the configuration except the identity
+// providers, which are a list
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "env", env: "APP_ENV", usage: "environment: development or production", value: (*stringValue)(&c.Env)},
+
+		{key: "log.level", env: "LOG_LEVEL", usage: "lowest level logged: debug, info, warn or error", value: (*stringValue)(&c.Log.Level)},
+		{key: "log.format", env: "LOG_FORMAT", usage: "log format: json or text", value: (*stringValue)(&c.Log.Format)},
+
+		{key: "http.port", env: "PORT", usage: "port to listen on", value: (*stringValue)(&c.HTTP.Port)},
+		{key: "http.base_url", env: "BASE_URL", usage: "public address of the server (default http://localhost:<port>)", value: (*stringValue)(&c.HTTP.BaseURL)},
+		{key: "http.trust_proxy", env: "TRUST_PROXY", usage: "take client addresses from X-Forwarded-For", value: (*boolValue)(&c.HTTP.TrustProxy)},
+		{key: "http.read_header_timeout", env: "HTTP_READ_HEADER_TIMEOUT", usage: "time allowed to read request headers", value: (*durationValue)(&c.HTTP.ReadHeaderTimeout)},
+		{key: "http.read_timeout", env: "HTTP_READ_TIMEOUT", usage: "time allowed to read a whole request, including uploads", value: (*durationValue)(&c.HTTP.ReadTimeout)},
+		{key: "http.write_timeout", env: "HTTP_WRITE_TIMEOUT", usage: "time allowed to write a response", value: (*durationValue)(&c.HTTP.WriteTimeout)},
+		{key: "http.idle_timeout", env: "HTTP_IDLE_TIMEOUT", usage: "how long an idle keep-alive connection stays open", value: (*durationValue)(&c.HTTP.IdleTimeout)},
+		{key: "http.shutdown_timeout", env: "SHUTDOWN_TIMEOUT", usage: "how long to wait for requests to finish when stopping", value: (*durationValue)(&c.HTTP.ShutdownTimeout)},
+
+		{key: "database.host", env: "DB_HOST", usage: "PostgreSQL host", value: (*stringValue)(&c.DB.Host)},
+		{key: "database.port", env: "DB_PORT", usage: "PostgreSQL port", value: (*stringValue)(&c.DB.Port)},
+		{key: "database.user", env: "DB_USER", usage: "PostgreSQL user", value: (*stringValue)(&c.DB.User)},
+		{key: "database.password", env: "DB_PASSWORD", usage: "PostgreSQL password", secret: true, value: (*stringValue)(&c.DB.Password)},
+		{key: "database.name", env: "DB_NAME", usage: "PostgreSQL database", value: (*stringValue)(&c.DB.DBName)},
+		{key: "database.sslmode", env: "DB_SSLMODE", usage: "PostgreSQL sslmode", value: (*stringValue)(&c.DB.SSLMode)},
+
+		{key: "events.bus", env: "EVENT_BUS", usage: "live update bus: memory or postgres", value: (*stringValue)(&c.Events.Bus)},
+
+		{key: "session.key", env: "SESSION_KEY", usage: "key that signs session cookies", secret: true, value: (*stringValue)(&c.Session.Key)},
+		{key: "session.idle_timeout", env: "SESSION_IDLE_TIMEOUT", usage: "how long an unused session lasts", value: (*durationValue)(&c.Session.IdleTimeout)},
+		{key: "session.lifetime", env: "SESSION_LIFETIME", usage: "how long a session lasts after sign-in", value: (*durationValue)(&c.Session.Lifetime)},
+
+		{key: "i18n.locales_dir", env: "LOCALES_DIR", usage: "directory of translation files overriding the built-in ones", value: (*stringValue)(&c.I18n.LocalesDir)},
+		{key: "i18n.default_language", env: "DEFAULT_LANGUAGE", usage: "language used when none is chosen", value: (*stringValue)(&c.I18n.DefaultLanguage)},
+
+		{key: "mail.mailer", env: "MAILER", usage: "how email is sent: log or smtp", value: (*stringValue)(&c.Mail.Mailer)},
+		{key: "mail.from", env: "MAIL_FROM", usage: "sender of outgoing email", value: (*stringValue)(&c.Mail.From)},
+		{key: "mail.dir", env: "MAIL_DIR", usage: "directory the log mailer saves messages to", value: (*stringValue)(&c.Mail.Dir)},
+		{key: "mail.smtp_host", env: "SMTP_HOST", usage: "SMTP relay host", value: (*stringValue)(&c.Mail.SMTPHost)},
+		{key: "mail.smtp_port", env: "SMTP_PORT", usage: "SMTP relay port", value: (*stringValue)(&c.Mail.SMTPPort)},
+		{key: "mail.smtp_username", env: "SMTP_USERNAME", usage: "SMTP relay username", value: (*stringValue)(&c.Mail.SMTPUsername)},
+		{key: "mail.smtp_password", env: "SMTP_PASSWORD", usage: "SMTP relay password", secret: true, value: (*stringValue)(&c.Mail.SMTPPassword)},
+
+		{key: "auth.admin_emails", env: "ADMIN_EMAILS", usage: "comma-separated addresses promoted to administrator", value: (*listValue)(&c.Auth.AdminEmails)},
+		{key: "auth.unverified_policy", env: "UNVERIFIED_POLICY", usage: "what unverified accounts may do: allow, read-only or block", value: (*stringValue)(&c.Auth.UnverifiedPolicy)},
+		{key: "auth.password_min_length", env: "PASSWORD_MIN_LENGTH", usage: "shortest password accepted", value: (*intValue)(&c.Auth.PasswordMinLength)},
+		{key: "auth.breached_passwords_file", env: "BREACHED_PASSWORDS_FILE", usage: "file of SHA-1 hashes of breached passwords to refuse", value: (*stringValue)(&c.Auth.BreachedPasswordsFile)},
+
+		{key: "metrics.token", env: "METRICS_TOKEN", usage: "bearer token required to read /metrics", secret: true, value: (*stringValue)(&c.Metrics.Token)},
+
+		{key: "tracing.endpoint", env: "OTEL_EXPORTER_OTLP_ENDPOINT", usage: "OTLP/HTTP collector to send traces to, such as http://localhost:4318", value: (*stringValue)(&c.Tracing.Endpoint)},
+		{key: "tracing.service_name", env: "OTEL_SERVICE_NAME", usage: "service name traces are recorded under", value: (*stringValue)(&c.Tracing.ServiceName)},
+		{key: "tracing.sample_ratio", env: "TRACING_SAMPLE_RATIO", usage: "share of requests traced, from 0 to 1", value: (*floatValue)(&c.Tracing.SampleRatio)},
+	}
+}
+
+// Load reads the configuration from the defaults, the config file, the
+// environment and the command-line arguments, in that order, and validates
+// it. Its flags are added to fs, which is then parsed with args.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := Default()
+	settings := c.settings()
+
+	// Flags are only collected while parsing, so they can be applied last
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file")
+	flags := make(map[string]string)
+	for _, s := range settings {
+		s := s
+		fs.Func(s.key, s.usage+" (env "+s.env+")", func(value string) error {
+			if err := s.value.Set(value); err != nil {
+				return err
+			}
+			flags[s.key] = value
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Parsing checked the flags against a scratch copy of the defaults
+	c = Default()
+	settings = c.settings()
+
+	if *configFile != "" {
+		if err := c.loadFile(*configFile, settings); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", *configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.value.Set(value); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", s.env, value, err)
+			}
+		}
+	}
+	providers, err := oidcFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if providers != nil {
+		c.OIDC = providers
+	}
+
+	for _, s := range settings {
+		if value, ok := flags[s.key]; ok {
+			s.value.Set(value)
+		}
+	}
+
+	if c.HTTP.BaseURL == "" {
+		c.HTTP.BaseURL = "http://localhost:" + c.HTTP.Port
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile applies the settings in a YAML file. Unknown keys are refused so a
+// typo does not go unnoticed.
+func (c *Config) loadFile(path string, settings []setting) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file struct {
+		OIDC []OIDCProviderConfig `yaml:"oidc"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	for _, p := range file.OIDC {
+		c.OIDC = append(c.OIDC, p.withDefaults())
+	}
+
+	var tree map[string]any
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return err
+	}
+	delete(tree, "oidc")
+	values := make(map[string]string)
+	flatten("", tree, values)
+
+	for _, s := range settings {
+		value, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		if err := s.value.Set(value); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", s.key, value, err)
+		}
+		delete(values, s.key)
+	}
+	for key := range values {
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	return nil
+}
+
+// flatten turns nested YAML maps into dotted keys. Lists become
+// comma-separated values.
+func flatten(prefix string, tree map[string]any, values map[string]string) {
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(key, v, values)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Validate checks that the settings can be used together
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Env == Development || c.Env == Production, "unknown env %q, expected development or production", c.Env)
+
+	_, err := logging.ParseLevel(c.Log.Level)
+	check(err == nil, "unknown log.level %q, expected debug, info, warn or error", c.Log.Level)
+	check(c.Log.Format == logging.FormatJSON || c.Log.Format == logging.FormatText,
+		"unknown log.format %q, expected json or text", c.Log.Format)
+
+	port, err := strconv.Atoi(c.HTTP.Port)
+	check(err == nil && port > 0 && port < 65536, "invalid http.port %q", c.HTTP.Port)
+	base, err := url.Parse(c.HTTP.BaseURL)
+	check(err == nil && (base.Scheme == "http" || base.Scheme == "https") && base.Host != "",
+		"invalid http.base_url %q, expected an address such as https://expenses.example.com", c.HTTP.BaseURL)
+
+	for _, timeout := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"http.read_header_timeout", c.HTTP.ReadHeaderTimeout},
+		{"http.read_timeout", c.HTTP.ReadTimeout},
+		{"http.write_timeout", c.HTTP.WriteTimeout},
+		{"http.idle_timeout", c.HTTP.IdleTimeout},
+		{"http.shutdown_timeout", c.HTTP.ShutdownTimeout},
+	} {
+		check(timeout.value > 0, "%s must be positive", timeout.key)
+	}
+
+	check(c.Events.Bus == "memory" || c.Events.Bus == "postgres", "unknown events.bus %q, expected memory or postgres", c.Events.Bus)
+
+	check(c.Session.Key != "", "session.key must be set")
+	check(c.Session.IdleTimeout > 0, "session.idle_timeout must be positive")
+	check(c.Session.Lifetime > 0, "session.lifetime must be positive")
+	if c.IsProduction() {
+		check(c.Session.Key != DefaultSessionKey, "session.key must be changed from the default in production")
+	}
+
+	check(c.I18n.DefaultLanguage != "", "i18n.default_language must be set")
+
+	switch c.Mail.Mailer {
+	case "log":
+	case "smtp":
+		check(c.Mail.SMTPHost != "", "mail.smtp_host is required with the smtp mailer")
+	default:
+		check(false, "unknown mail.mailer %q, expected log or smtp", c.Mail.Mailer)
+	}
+
+	check(models.IsValidUnverifiedPolicy(c.Auth.UnverifiedPolicy),
+		"unknown auth.unverified_policy %q, expected allow, read-only or block", c.Auth.UnverifiedPolicy)
+	check(c.Auth.PasswordMinLength >= 1 && c.Auth.PasswordMinLength <= passwords.MaxBytes,
+		"auth.password_min_length must be between 1 and %d", passwords.MaxBytes)
+
+	if c.Tracing.Endpoint != "" {
+		endpoint, err := url.Parse(c.Tracing.Endpoint)
+		check(err == nil && (endpoint.Scheme == "http" || endpoint.Scheme == "https") && endpoint.Host != "",
+			"invalid tracing.endpoint %q, expected an address such as http://localhost:4318", c.Tracing.Endpoint)
+		check(c.Tracing.ServiceName != "", "tracing.service_name must be set")
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	seen := make(map[string]bool)
+	for _, p := range c.OIDC {
+		check(providerID.MatchString(p.ID), "invalid identity provider ID %q, expected lowercase letters, digits and dashes", p.ID)
+		check(!seen[p.ID], "identity provider %q is configured twice", p.ID)
+		check(p.Issuer != "" && p.ClientID != "" && p.ClientSecret != "",
+			"identity provider %q needs an issuer, client ID and client secret", p.ID)
+		seen[p.ID] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+// IsProduction reports whether the server runs in production
+func (c *Config) IsProduction() bool {
+	return c.Env == Production
+}
+
+// Print writes the configuration in the config file format, with secrets
+// such as passwords and keys redacted
+func (c *Config) Print(w io.Writer) {
+	for _, s := range c.settings() {
+		fmt.Fprintf(w, "%s: %s\n", s.key, strconv.Quote(redact(s.value.String(), s.secret)))
+	}
+	if len(c.OIDC) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "oidc:")
+	for _, p := range c.OIDC {
+		fmt.Fprintf(w, "  - id: %s\n", strconv.Quote(p.ID))
+		fmt.Fprintf(w, "    name: %s\n", strconv.Quote(p.Name))
+		fmt.Fprintf(w, "    issuer: %s\n", strconv.Quote(p.Issuer))
+		fmt.Fprintf(w, "    client_id: %s\n", strconv.Quote(p.ClientID))
+		fmt.Fprintf(w, "    client_secret: %s\n", strconv.Quote(redact(p.ClientSecret, true)))
+		scopes := make([]string, len(p.Scopes))
+		for i, scope := range p.Scopes {
+			scopes[i] = strconv.Quote(scope)
+		}
+		fmt.Fprintf(w, "    scopes: [%s]\n", strings.Join(scopes, ", "))
+		fmt.Fprintf(w, "    auto_provision: %t\n", p.AutoProvision)
+	}
+}
+
+// redact hides the value of a secret that is set
+func redact(value string, secret bool) string {
+	if secret && value != "" {
+		return "[redacted]"
+	}
+	return value
+}
+
+// Values that parse settings from text, for flags, the environment and the
+// config file alike
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return errors.New("expected true or false")
+	}
+	*v = boolValue(b)
+	return nil
+}
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return errors.New("expected a number")
+	}
+	*v = intValue(n)
+	return nil
+}
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type floatValue float64
+
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return errors.New("expected a number such as 0.5")
+	}
+	*v = floatValue(f)
+	return nil
+}
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'g', -1, 64) }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.New("expected a duration such as 12h")
+	}
+	*v = durationValue(d)
+	return nil
+}
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	*v = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+	return nil
+}
+func (v *listValue) String() string { return strings.Join(*v, ",") }
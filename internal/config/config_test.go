@@ -0,0 +1,194 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// load runs Load with only the given config file, environment and arguments
+func load(t *testing.T, file string, env map[string]string, args ...string) (*Config, error) {
+	t.Helper()
+	for _, s := range Default().settings() {
+		t.Setenv(s.env, "")
+	}
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("OIDC_PROVIDERS", "")
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	if file != "" {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"-config", path}, args...)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		env         map[string]string
+		args        []string
+		port        string
+		readTimeout time.Duration
+		trustProxy  bool
+	}{
+		{
+			name:        "defaults",
+			port:        "8080",
+			readTimeout: time.Minute,
+		},
+		{
+			name:        "file over defaults",
+			file:        "http:\n  port: 8081\n  read_timeout: 2m\n  trust_proxy: true\n",
+			port:        "8081",
+			readTimeout: 2 * time.Minute,
+			trustProxy:  true,
+		},
+		{
+			name:        "dotted file keys",
+			file:        "http.port: 8081\nhttp.read_timeout: 2m\n",
+			port:        "8081",
+			readTimeout: 2 * time.Minute,
+		},
+		{
+			name:        "environment over file",
+			file:        "http:\n  port: 8081\n  read_timeout: 2m\n  trust_proxy: true\n",
+			env:         map[string]string{"PORT": "8082", "TRUST_PROXY": "false"},
+			port:        "8082",
+			readTimeout: 2 * time.Minute,
+		},
+		{
+			name:        "flags over environment",
+			file:        "http:\n  port: 8081\n  read_timeout: 2m\n",
+			env:         map[string]string{"PORT": "8082", "HTTP_READ_TIMEOUT": "3m"},
+			args:        []string{"-http.port=8083", "-http.trust_proxy=true"},
+			port:        "8083",
+			readTimeout: 3 * time.Minute,
+			trustProxy:  true,
+		},
+		{
+			name:        "flags over file",
+			file:        "http:\n  port: 8081\n",
+			args:        []string{"-http.port=8083"},
+			port:        "8083",
+			readTimeout: time.Minute,
+		},
+		{
+			name:        "empty environment variables are unset",
+			file:        "http:\n  port: 8081\n",
+			env:         map[string]string{"PORT": ""},
+			port:        "8081",
+			readTimeout: time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := load(t, tt.file, tt.env, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.HTTP.Port != tt.port {
+				t.Errorf("http.port = %q, want %q", c.HTTP.Port, tt.port)
+			}
+			if c.HTTP.ReadTimeout != tt.readTimeout {
+				t.Errorf("http.read_timeout = %v, want %v", c.HTTP.ReadTimeout, tt.readTimeout)
+			}
+			if c.HTTP.TrustProxy != tt.trustProxy {
+				t.Errorf("http.trust_proxy = %t, want %t", c.HTTP.TrustProxy, tt.trustProxy)
+			}
+			if want := "http://localhost:" + tt.port; c.HTTP.BaseURL != want {
+				t.Errorf("http.base_url = %q, want %q", c.HTTP.BaseURL, want)
+			}
+		})
+	}
+}
+
+func TestLoadRefusesBadSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{
+			name: "unknown nested key",
+			file: "http:\n  prot: 9000\n",
+			want: "unknown setting http.prot",
+		},
+		{
+			name: "unknown top-level key",
+			file: "colour: blue\n",
+			want: "unknown setting colour",
+		},
+		{
+			name: "unknown dotted key",
+			file: "database.hostname: db\n",
+			want: "unknown setting database.hostname",
+		},
+		{
+			name: "malformed YAML",
+			file: "http:\n  port: [8080\n",
+			want: "yaml:",
+		},
+		{
+			name: "list instead of a map",
+			file: "- http.port\n- 8080\n",
+			want: "yaml:",
+		},
+		{
+			name: "malformed bool in the file",
+			file: "http:\n  trust_proxy: maybe\n",
+			want: `invalid http.trust_proxy "maybe"`,
+		},
+		{
+			name: "malformed duration in the file",
+			file: "session:\n  idle_timeout: 5\n",
+			want: `invalid session.idle_timeout "5"`,
+		},
+		{
+			name: "malformed environment variable",
+			env:  map[string]string{"PASSWORD_MIN_LENGTH": "eight"},
+			want: `invalid PASSWORD_MIN_LENGTH "eight"`,
+		},
+		{
+			name: "malformed flag",
+			args: []string{"-http.read_timeout=soon"},
+			want: "http.read_timeout",
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-http.prot=9000"},
+			want: "http.prot",
+		},
+		{
+			name: "valid syntax, invalid value",
+			file: "log:\n  level: loud\n",
+			want: `unknown log.level "loud"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(t, tt.file, tt.env, tt.args...)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
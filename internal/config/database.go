@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
 // DBConfig holds database configuration
@@ -15,16 +16,20 @@ type DBConfig struct {
 	SSLMode  string
 }
 
-// NewDBConfig creates a new database configuration from environment variables
+// NewDBConfig creates a new database configuration from environment
+// variables, for tools that only need the database. The server reads it as
+// part of its full configuration with Load.
 func NewDBConfig() *DBConfig {
-	return &DBConfig{
-		Host:     getEnvOrDefault("DB_HOST", "localhost"),
-		Port:     getEnvOrDefault("DB_PORT", "5432"),
-		User:     getEnvOrDefault("DB_USER", "postgres"),
-		Password: getEnvOrDefault("DB_PASSWORD", "postgres"),
-		DBName:   getEnvOrDefault("DB_NAME", "expensemanager"),
-		SSLMode:  getEnvOrDefault("DB_SSLMODE", "disable"),
+	c := Default()
+	for _, s := range c.settings() {
+		if !strings.HasPrefix(s.key, "database.") {
+			continue
+		}
+		if value := os.Getenv(s.env); value != "" {
+			s.value.Set(value)
+		}
 	}
+	return &c.DB
 }
 
 // PostgresConnectionString returns a connection string for PostgreSQL
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// OIDCProviderConfig holds the configuration of one OpenID Connect identity
+// provider users can sign in with
+type OIDCProviderConfig struct {
+	// ID names the provider in URLs and environment variables
+	ID           string   `yaml:"id"`
+	Name         string   `yaml:"name"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+	// AutoProvision creates accounts for unknown users of the provider
+	AutoProvision bool `yaml:"auto_provision"`
+}
+
+// defaultScopes are requested when a provider names none
+var defaultScopes = []string{"openid", "email", "profile"}
+
+// withDefaults fills in the optional settings of a provider from a config file
+func (p OIDCProviderConfig) withDefaults() OIDCProviderConfig {
+	if p.Name == "" {
+		p.Name = p.ID
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = defaultScopes
+	}
+	return p
+}
+
+var providerID = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// oidcFromEnv reads the identity providers listed in OIDC_PROVIDERS, a
+// comma-separated list of IDs, or returns nil if the variable is unset. Each provider is configured with variables
+// named after its ID, for example OIDC_CORP_ISSUER for the provider "corp":
+// _ISSUER, _CLIENT_ID and _CLIENT_SECRET are required, while _NAME (shown on
+// the sign-in button), _SCOPES and _AUTO_PROVISION are optional.
+func oidcFromEnv() ([]OIDCProviderConfig, error) {
+	if os.Getenv("OIDC_PROVIDERS") == "" {
+		return nil, nil
+	}
+	providers := []OIDCProviderConfig{}
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !providerID.MatchString(id) {
+			return nil, fmt.Errorf("invalid provider ID %q in OIDC_PROVIDERS, expected lowercase letters, digits and dashes", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("provider %q is listed twice in OIDC_PROVIDERS", id)
+		}
+		seen[id] = true
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(id, "-", "_")) + "_"
+		provider := OIDCProviderConfig{
+			ID:           id,
+			Name:         getEnvOrDefault(prefix+"NAME", id),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		for _, name := range []string{"ISSUER", "CLIENT_ID", "CLIENT_SECRET"} {
+			if os.Getenv(prefix+name) == "" {
+				return nil, fmt.Errorf("%s%s is required for provider %q", prefix, name, id)
+			}
+		}
+		switch value := os.Getenv(prefix + "AUTO_PROVISION"); value {
+		case "", "false":
+		case "true":
+			provider.AutoProvision = true
+		default:
+			return nil, fmt.Errorf("invalid %sAUTO_PROVISION %q, expected true or false", prefix, value)
+		}
+		providers = append(providers, provider.withDefaults())
+	}
+	return providers, nil
+}
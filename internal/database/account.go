@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"expensemanager/internal/models"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrEmailTaken is returned when an email address belongs to another account
+var ErrEmailTaken = errors.New("email address already registered")
+
+// CheckPassword reports whether password is the user's current password
+func CheckPassword(user *models.User, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+}
+
+// UpdateUserName changes the name shown for the user
+func (db *DB) UpdateUserName(userID int64, name string) error {
+	_, err := db.Exec(`UPDATE users SET name = $1, updated_at = $2 WHERE id = $3`, name, time.Now(), userID)
+	return err
+}
+
+// SetPendingEmail remembers the address the user wants to change to until
+// they confirm it with ConfirmEmailChange
+func (db *DB) SetPendingEmail(userID int64, email string) error {
+	_, err := db.Exec(`UPDATE users SET pending_email = $1, updated_at = $2 WHERE id = $3`, email, time.Now(), userID)
+	return err
+}
+
+// ConfirmEmailChange consumes an email change token and makes the pending
+// address the user's verified email. It returns sql.ErrNoRows if the token is
+// unknown, used or expired, and ErrEmailTaken if another account registered
+// the address meanwhile.
+func (db *DB) ConfirmEmailChange(tokenHash string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int64
+	err = tx.QueryRow(`
+		UPDATE user_tokens
+		SET used_at = $1
+		WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id
+	`, now, tokenHash, models.TokenPurposeEmailChange).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE users
+		SET email = pending_email, pending_email = NULL, email_verified_at = $1, updated_at = $1
+		WHERE id = $2 AND pending_email IS NOT NULL
+	`, now, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return 0, ErrEmailTaken
+		}
+		return 0, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// ChangePassword sets a new password and signs the user out of every session
+// except the one with keepSessionHash. It returns the new session version,
+// which the kept session must store to stay signed in.
+func (db *DB) ChangePassword(userID int64, password, keepSessionHash string) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	err = tx.QueryRow(`
+		UPDATE users
+		SET password = $1, must_reset_password = FALSE, session_version = session_version + 1, updated_at = $2
+		WHERE id = $3
+		RETURNING session_version
+	`, string(hashedPassword), time.Now(), userID).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM sessions WHERE user_id = $1 AND token_hash <> $2`, userID, keepSessionHash)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+// likeEscaper escapes the wildcards of LIKE patterns, whose escape
+// character is a backslash by default
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// ListUsers returns one page of accounts, oldest first, with their expense
+// counts. A non-empty search limits the list to emails or names containing it
+// literally. It also returns the total number of matching accounts.
+func (db *DB) ListUsers(search string, limit, offset int) ([]models.UserSummary, int, error) {
+	pattern := "%" + likeEscaper.Replace(search) + "%"
+
+	var total int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM users
+		WHERE $1 = '' OR email ILIKE $2 OR name ILIKE $2
+	`, search, pattern).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT u.id, u.email, u.name, u.role, u.email_verified_at, u.totp_enabled_at,
+			u.disabled_at, u.last_login_at, u.created_at, u.updated_at,
+			(SELECT COUNT(*) FROM expenses e WHERE e.user_id = u.id)
+		FROM users u
+		WHERE $1 = '' OR u.email ILIKE $2 OR u.name ILIKE $2
+		ORDER BY u.created_at, u.id
+		LIMIT $3 OFFSET $4
+	`, search, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []models.UserSummary
+	for rows.Next() {
+		var u models.UserSummary
+		err := rows.Scan(
+			&u.ID,
+			&u.Email,
+			&u.Name,
+			&u.Role,
+			&u.EmailVerifiedAt,
+			&u.TOTPEnabledAt,
+			&u.DisabledAt,
+			&u.LastLoginAt,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+			&u.ExpenseCount,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
+}
+
+// SetUserDisabled disables or re-enables an account. Disabling also signs the
+// user out of every session. It returns sql.ErrNoRows if the user does not exist.
+func (db *DB) SetUserDisabled(userID int64, disabled bool) error {
+	query := `UPDATE users SET disabled_at = NULL, updated_at = $1 WHERE id = $2`
+	if disabled {
+		query = `
+			UPDATE users
+			SET disabled_at = $1, session_version = session_version + 1, updated_at = $1
+			WHERE id = $2
+		`
+	}
+	result, err := db.Exec(query, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	if disabled {
+		return db.DeleteUserSessions(userID)
+	}
+	return nil
+}
+
+// InvalidatePassword removes the user's password, marks the account as having
+// to reset it and signs them out of every session, so the account can only be
+// used again after a password reset; single sign-on and passkeys are refused
+// until then too. It returns sql.ErrNoRows if the user does not exist.
+func (db *DB) InvalidatePassword(userID int64) error {
+	result, err := db.Exec(`
+		UPDATE users
+		SET password = $1, must_reset_password = TRUE, session_version = session_version + 1, updated_at = $2
+		WHERE id = $3
+	`, models.NoPassword, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return db.DeleteUserSessions(userID)
+}
+
+// DeleteUser deletes an account together with its expenses, tokens and every
+// other row that belongs to it. It returns sql.ErrNoRows if the user does not exist.
+func (db *DB) DeleteUser(userID int64) error {
+	result, err := db.Exec(`DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetStorageUsage reports the size of the database and of each table
+func (db *DB) GetStorageUsage() (*models.StorageUsage, error) {
+	usage := &models.StorageUsage{}
+	err := db.QueryRow(`
+		SELECT pg_database_size(current_database()),
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM expenses)
+	`).Scan(&usage.DatabaseBytes, &usage.Users, &usage.Expenses)
+	if err != nil {
+		return nil, err
+	}
+
+	// Row counts are the planner's estimates, which are cheap to read
+	rows, err := db.Query(`
+		SELECT c.relname, GREATEST(c.reltuples, 0)::BIGINT, pg_total_relation_size(c.oid)
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'r' AND n.nspname = current_schema()
+		ORDER BY pg_total_relation_size(c.oid) DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.TableUsage
+		if err := rows.Scan(&t.Name, &t.Rows, &t.Bytes); err != nil {
+			return nil, err
+		}
+		usage.Tables = append(usage.Tables, t)
+	}
+	return usage, rows.Err()
+}
+
+// AddAuditEntry records an administrator's action
+func (db *DB) AddAuditEntry(entry *models.AuditEntry) error {
+	now := time.Now()
+	err := db.QueryRow(`
+		INSERT INTO audit_log (actor_id, actor_email, action, target_user_id, target_email, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, entry.ActorID, entry.ActorEmail, entry.Action, entry.TargetUserID, entry.TargetEmail, entry.Details, now).Scan(&entry.ID)
+	if err != nil {
+		return err
+	}
+
+	entry.CreatedAt = now
+	return nil
+}
+
+// GetAuditLog returns one page of the audit log, newest first, and the total
+// number of entries
+func (db *DB) GetAuditLog(limit, offset int) ([]models.AuditEntry, int, error) {
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM audit_log`).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, actor_id, actor_email, action, target_user_id, target_email, details, created_at
+		FROM audit_log
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.ActorID,
+			&e.ActorEmail,
+			&e.Action,
+			&e.TargetUserID,
+			&e.TargetEmail,
+			&e.Details,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+// CreateCredential stores a newly registered passkey
+func (db *DB) CreateCredential(c *models.Credential) error {
+	now := time.Now()
+	err := db.QueryRow(`
+		INSERT INTO credentials (user_id, credential_id, public_key, attestation_type, aaguid,
+			sign_count, transports, backup_eligible, backup_state, name, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`, c.UserID, c.CredentialID, c.PublicKey, c.AttestationType, c.AAGUID,
+		int64(c.SignCount), strings.Join(c.Transports, ","), c.BackupEligible, c.BackupState, c.Name, now).Scan(&c.ID)
+	if err != nil {
+		return err
+	}
+
+	c.CreatedAt = now
+	return nil
+}
+
+// GetCredentials returns the passkeys registered to the user, oldest first
+func (db *DB) GetCredentials(userID int64) ([]models.Credential, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, credential_id, public_key, attestation_type, aaguid, sign_count,
+			transports, backup_eligible, backup_state, name, last_used_at, created_at
+		FROM credentials
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []models.Credential
+	for rows.Next() {
+		var c models.Credential
+		var signCount int64
+		var transports string
+		err := rows.Scan(
+			&c.ID,
+			&c.UserID,
+			&c.CredentialID,
+			&c.PublicKey,
+			&c.AttestationType,
+			&c.AAGUID,
+			&signCount,
+			&transports,
+			&c.BackupEligible,
+			&c.BackupState,
+			&c.Name,
+			&c.LastUsedAt,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		c.SignCount = uint32(signCount)
+		if transports != "" {
+			c.Transports = strings.Split(transports, ",")
+		}
+		credentials = append(credentials, c)
+	}
+	return credentials, rows.Err()
+}
+
+// UpdateCredentialUse records a successful sign-in with a passkey, storing the
+// authenticator's new signature counter and backup state
+func (db *DB) UpdateCredentialUse(credentialID []byte, signCount uint32, backupState bool) error {
+	result, err := db.Exec(`
+		UPDATE credentials
+		SET sign_count = $1, backup_state = $2, last_used_at = $3
+		WHERE credential_id = $4
+	`, int64(signCount), backupState, time.Now(), credentialID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteCredential removes one of the user's passkeys
+func (db *DB) DeleteCredential(userID, id int64) error {
+	result, err := db.Exec("DELETE FROM credentials WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
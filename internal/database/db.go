@@ -1,8 +1,12 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"expensemanager/internal/models"
@@ -13,6 +17,8 @@ import (
 
 type DB struct {
 	*sql.DB
+	// Context of the request the queries are run for; see WithContext
+	ctx context.Context
 }
 
 func NewDB(dataSourceName string) (*DB, error) {
@@ -25,7 +31,7 @@ func NewDB(dataSourceName string) (*DB, error) {
 		return nil, err
 	}
 
-	return &DB{db}, nil
+	return &DB{DB: db}, nil
 }
 
 func (db *DB) Initialize() error {
@@ -44,6 +50,227 @@ func (db *DB) Initialize() error {
 		return err
 	}
 
+	// Bumping session_version signs the user out of every session
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS session_version INTEGER NOT NULL DEFAULT 0`)
+	if err != nil {
+		return err
+	}
+
+	// Accounts that existed before email verification count as verified: the
+	// default fills existing rows once and is dropped so new users start unverified
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE users ALTER COLUMN email_verified_at DROP DEFAULT`)
+	if err != nil {
+		return err
+	}
+
+	// Two-factor authentication: the shared TOTP secret, when it was enabled and
+	// the last time step accepted, so a code cannot be replayed
+	_, err = db.Exec(`
+		ALTER TABLE users
+			ADD COLUMN IF NOT EXISTS totp_secret TEXT,
+			ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS totp_last_counter BIGINT
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Roles: "user" or "admin"; administrators are bootstrapped with PromoteAdmins
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user'`)
+	if err != nil {
+		return err
+	}
+
+	// Lockout: consecutive failed sign-ins and, once too many, when the
+	// account may be tried again
+	_, err = db.Exec(`
+		ALTER TABLE users
+			ADD COLUMN IF NOT EXISTS failed_login_count INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Account administration: when the user last signed in, and whether an
+	// administrator has disabled the account
+	_, err = db.Exec(`
+		ALTER TABLE users
+			ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP
+	`)
+	if err != nil {
+		return err
+	}
+
+	// A new email address waits here until the link sent to it is opened
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email TEXT`)
+	if err != nil {
+		return err
+	}
+
+	// Set when an administrator forces a password reset, until the user
+	// chooses a new password
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS must_reset_password BOOLEAN NOT NULL DEFAULT FALSE`)
+	if err != nil {
+		return err
+	}
+
+	// Create audit log table (actions taken by administrators). Entries keep
+	// the emails involved and outlive the accounts.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id SERIAL PRIMARY KEY,
+			actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			actor_email TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target_user_id INTEGER,
+			target_email TEXT NOT NULL DEFAULT '',
+			details TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create login attempts table (failed sign-ins shown to the account owner)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_attempts (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			ip TEXT NOT NULL,
+			user_agent TEXT NOT NULL DEFAULT '',
+			reason TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS login_attempts_user_id_idx ON login_attempts (user_id, created_at)`)
+	if err != nil {
+		return err
+	}
+
+	// Create recovery codes table (single-use 2FA fallbacks)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, code_hash)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create credentials table (passkeys registered with WebAuthn)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS credentials (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			credential_id BYTEA UNIQUE NOT NULL,
+			public_key BYTEA NOT NULL,
+			attestation_type TEXT NOT NULL DEFAULT '',
+			aaguid BYTEA,
+			sign_count BIGINT NOT NULL DEFAULT 0,
+			transports TEXT NOT NULL DEFAULT '',
+			backup_eligible BOOLEAN NOT NULL DEFAULT FALSE,
+			backup_state BOOLEAN NOT NULL DEFAULT FALSE,
+			name TEXT NOT NULL,
+			last_used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create user identities table (accounts at OpenID Connect providers
+	// linked to users; the subject is the provider's stable ID for the user)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			email TEXT NOT NULL DEFAULT '',
+			last_login_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (provider, subject)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create sessions table (browser sessions; the cookie only holds a token)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			id SERIAL PRIMARY KEY,
+			token_hash TEXT UNIQUE NOT NULL,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			data BYTEA NOT NULL,
+			ip TEXT NOT NULL DEFAULT '',
+			user_agent TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at)`)
+	if err != nil {
+		return err
+	}
+
+	// Create single-use user tokens table (password reset and verification links)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS user_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			purpose TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create API tokens table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			scope TEXT NOT NULL,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
 	// Drop existing expenses table if it exists
 	_, err = db.Exec(`DROP TABLE IF EXISTS expenses`)
 	if err != nil {
@@ -60,12 +287,39 @@ func (db *DB) Initialize() error {
 			category TEXT NOT NULL,
 			date DATE NOT NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			client_id UUID,
+			UNIQUE (user_id, client_id)
 		)
 	`)
 	return err
 }
 
+// schemaTables are the tables Initialize creates
+var schemaTables = []string{
+	"users", "audit_log", "login_attempts", "recovery_codes", "credentials",
+	"user_identities", "sessions", "user_tokens", "api_tokens", "expenses",
+}
+
+// CheckSchema reports an error naming the tables Initialize creates that are
+// missing from the database
+func (db *DB) CheckSchema(ctx context.Context) error {
+	var missing []string
+	for _, table := range schemaTables {
+		var exists bool
+		if err := db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
 // User-related functions
 func (db *DB) CreateUser(user *models.User, password string) error {
 	// Hash the password
@@ -85,22 +339,43 @@ func (db *DB) CreateUser(user *models.User, password string) error {
 		return err
 	}
 
+	user.Role = models.RoleUser
 	user.CreatedAt = now
 	user.UpdatedAt = now
 	return nil
 }
 
 func (db *DB) GetUserByEmail(email string) (*models.User, error) {
+	return db.getUser(`email = $1`, email)
+}
+
+// GetUserByID looks up a user by ID, returning nil if there is none
+func (db *DB) GetUserByID(userID int64) (*models.User, error) {
+	return db.getUser(`id = $1`, userID)
+}
+
+// getUser loads the user matching the condition, returning nil if there is none
+func (db *DB) getUser(condition string, arg interface{}) (*models.User, error) {
 	user := &models.User{}
 	err := db.QueryRow(`
-		SELECT id, email, password, name, created_at, updated_at
+		SELECT id, email, COALESCE(pending_email, ''), password, name, role, session_version, email_verified_at,
+			COALESCE(totp_secret, ''), totp_enabled_at, locked_until, disabled_at, must_reset_password,
+			created_at, updated_at
 		FROM users
-		WHERE email = $1
-	`, email).Scan(
+		WHERE `+condition, arg).Scan(
 		&user.ID,
 		&user.Email,
+		&user.PendingEmail,
 		&user.Password,
 		&user.Name,
+		&user.Role,
+		&user.SessionVersion,
+		&user.EmailVerifiedAt,
+		&user.TOTPSecret,
+		&user.TOTPEnabledAt,
+		&user.LockedUntil,
+		&user.DisabledAt,
+		&user.MustResetPassword,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -113,18 +388,57 @@ func (db *DB) GetUserByEmail(email string) (*models.User, error) {
 	return user, nil
 }
 
-func (db *DB) AuthenticateUser(email, password string) (*models.User, error) {
+// Errors returned by AuthenticateUser. An unknown email and a wrong password
+// give the same error so callers cannot tell which accounts exist.
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrAccountLocked      = errors.New("account temporarily locked")
+	ErrAccountDisabled    = errors.New("account disabled")
+)
+
+// dummyPasswordHash is compared against when the email is unknown or the
+// account has no password, so the request takes as long as one for an account
+// with a password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// AuthenticateUser checks an email and password. Failures against an existing
+// account are recorded in its login attempts, and too many in a row lock the
+// account for a while, during which even the right password is refused.
+func (db *DB) AuthenticateUser(email, password string, attempt *models.LoginAttempt) (*models.User, error) {
 	user, err := db.GetUserByEmail(email)
 	if err != nil {
 		return nil, err
 	}
-	if user == nil {
-		return nil, errors.New("user not found")
+	// Accounts without a password take as long to refuse as unknown emails,
+	// so timing does not tell which ones sign in another way
+	if user == nil || !user.HasPassword() {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
-	if err != nil {
-		return nil, errors.New("invalid password")
+	passwordErr := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+
+	if user.Locked(time.Now()) {
+		if err := db.RecordLoginFailure(user.ID, attempt, models.LoginFailedLocked); err != nil {
+			return nil, err
+		}
+		return nil, ErrAccountLocked
+	}
+	if passwordErr != nil {
+		if err := db.RecordLoginFailure(user.ID, attempt, models.LoginFailedPassword); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+	// Only reveal that an account is disabled to someone who knows its password
+	if user.Disabled() {
+		return nil, ErrAccountDisabled
 	}
 
 	return user, nil
@@ -133,7 +447,7 @@ func (db *DB) AuthenticateUser(email, password string) (*models.User, error) {
 // Update expense-related functions to include user_id
 func (db *DB) GetExpenses(userID int64) ([]models.Expense, error) {
 	rows, err := db.Query(`
-		SELECT id, user_id, amount, description, category, date, created_at, updated_at
+		SELECT id, user_id, amount, description, category, date, created_at, updated_at, COALESCE(client_id::text, '')
 		FROM expenses 
 		WHERE user_id = $1
 		ORDER BY date DESC
@@ -155,6 +469,7 @@ func (db *DB) GetExpenses(userID int64) ([]models.Expense, error) {
 			&e.Date,
 			&e.CreatedAt,
 			&e.UpdatedAt,
+			&e.ClientID,
 		)
 		if err != nil {
 			return nil, err
@@ -166,7 +481,7 @@ func (db *DB) GetExpenses(userID int64) ([]models.Expense, error) {
 
 func (db *DB) GetExpensesByMonth(userID int64, year int, month int) ([]models.Expense, error) {
 	rows, err := db.Query(`
-		SELECT id, user_id, amount, description, category, date, created_at, updated_at
+		SELECT id, user_id, amount, description, category, date, created_at, updated_at, COALESCE(client_id::text, '')
 		FROM expenses 
 		WHERE user_id = $1
 		AND EXTRACT(YEAR FROM date) = $2 
@@ -190,6 +505,7 @@ func (db *DB) GetExpensesByMonth(userID int64, year int, month int) ([]models.Ex
 			&e.Date,
 			&e.CreatedAt,
 			&e.UpdatedAt,
+			&e.ClientID,
 		)
 		if err != nil {
 			return nil, err
@@ -216,6 +532,84 @@ func (db *DB) AddExpense(e *models.Expense) error {
 	return nil
 }
 
+func (db *DB) GetExpense(userID, expenseID int64) (*models.Expense, error) {
+	e := &models.Expense{}
+	err := db.QueryRow(`
+		SELECT id, user_id, amount, description, category, date, created_at, updated_at, COALESCE(client_id::text, '')
+		FROM expenses
+		WHERE id = $1 AND user_id = $2
+	`, expenseID, userID).Scan(
+		&e.ID,
+		&e.UserID,
+		&e.Amount,
+		&e.Description,
+		&e.Category,
+		&e.Date,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+		&e.ClientID,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (db *DB) UpdateExpense(e *models.Expense) error {
+	now := time.Now()
+	result, err := db.Exec(`
+		UPDATE expenses
+		SET amount = $1, description = $2, category = $3, date = $4, updated_at = $5
+		WHERE id = $6 AND user_id = $7
+	`, e.Amount, e.Description, e.Category, e.Date, now, e.ID, e.UserID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	e.UpdatedAt = now
+	return nil
+}
+
+// ImportExpenses adds all expenses in a single transaction, so a failing
+// row leaves the user's data untouched
+func (db *DB) ImportExpenses(expenses []models.Expense) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO expenses (user_id, amount, description, category, date, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, e := range expenses {
+		if _, err := stmt.Exec(e.UserID, e.Amount, e.Description, e.Category, e.Date, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (db *DB) DeleteExpense(userID, expenseID int64) error {
 	result, err := db.Exec("DELETE FROM expenses WHERE id = $1 AND user_id = $2", expenseID, userID)
 	if err != nil {
@@ -302,6 +696,68 @@ func (db *DB) GetAnalytics(userID int64) (models.Analytics, error) {
 	return analytics, nil
 }
 
+// GetYearlyReport returns per-month and per-category totals for one year.
+// Every month of the year is present in MonthlyTotals, in calendar order.
+func (db *DB) GetYearlyReport(userID int64, year int) (models.YearlyReport, error) {
+	report := models.YearlyReport{
+		Year:           year,
+		MonthlyTotals:  make([]models.MonthlyTotal, 12),
+		CategoryTotals: make([]models.CategoryTotal, 0),
+	}
+	for i := range report.MonthlyTotals {
+		report.MonthlyTotals[i].Month = fmt.Sprintf("%04d-%02d", year, i+1)
+	}
+
+	rows, err := db.Query(`
+		SELECT EXTRACT(MONTH FROM date)::int AS month, COALESCE(SUM(amount), 0)
+		FROM expenses
+		WHERE user_id = $1
+		AND EXTRACT(YEAR FROM date) = $2
+		GROUP BY month
+	`, userID, year)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var month int
+		var total float64
+		if err := rows.Scan(&month, &total); err != nil {
+			return report, err
+		}
+		report.MonthlyTotals[month-1].Total = total
+		report.Total += total
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	report.MonthlyAverage = report.Total / 12
+
+	rows, err = db.Query(`
+		SELECT category, COALESCE(SUM(amount), 0) AS total
+		FROM expenses
+		WHERE user_id = $1
+		AND EXTRACT(YEAR FROM date) = $2
+		GROUP BY category
+		ORDER BY total DESC
+	`, userID, year)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ct models.CategoryTotal
+		if err := rows.Scan(&ct.Category, &ct.Total); err != nil {
+			return report, err
+		}
+		report.CategoryTotals = append(report.CategoryTotals, ct)
+	}
+
+	return report, rows.Err()
+}
+
 func (db *DB) ClearExpenses(userID int64) error {
 	_, err := db.Exec("DELETE FROM expenses WHERE user_id = $1", userID)
 	return err
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+// GetUserByIdentity looks up the user linked to an identity provider's
+// subject, returning nil if none is
+func (db *DB) GetUserByIdentity(provider, subject string) (*models.User, error) {
+	var userID int64
+	err := db.QueryRow(`
+		SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2
+	`, provider, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.GetUserByID(userID)
+}
+
+// RecordIdentityLogin links the identity to the user if it is not linked yet,
+// and records a sign-in with it along with the email the provider sent
+func (db *DB) RecordIdentityLogin(userID int64, provider, subject, email string) error {
+	now := time.Now()
+	_, err := db.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (provider, subject) DO UPDATE SET email = $4, last_login_at = $5
+	`, userID, provider, subject, email, now)
+	return err
+}
+
+// CreateIdentityUser creates an account for a new user of an identity
+// provider and links it. The provider has verified the email address, and the
+// account has no password until the user resets it.
+func (db *DB) CreateIdentityUser(user *models.User, provider, subject string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	err = tx.QueryRow(`
+		INSERT INTO users (email, password, name, email_verified_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4, $4)
+		RETURNING id
+	`, user.Email, models.NoPassword, user.Name, now).Scan(&user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+	`, user.ID, provider, subject, user.Email, now)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	user.Password = models.NoPassword
+	user.Role = models.RoleUser
+	user.EmailVerifiedAt = &now
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	return nil
+}
+
+// GetIdentities returns the identity providers linked to the user, oldest first
+func (db *DB) GetIdentities(userID int64) ([]models.Identity, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, provider, subject, email, last_login_at, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []models.Identity
+	for rows.Next() {
+		var i models.Identity
+		err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.LastLoginAt, &i.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// DeleteIdentity unlinks one of the user's identities. It returns
+// sql.ErrNoRows if the identity does not exist or belongs to another user.
+func (db *DB) DeleteIdentity(userID, id int64) error {
+	result, err := db.Exec(`DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"strings"
+	"time"
+
+	"expensemanager/internal/metrics"
+	"expensemanager/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithContext returns a copy of the database whose queries belong to the
+// request ctx comes from, so they show as spans of the request's trace. The
+// copy is cheap and shares the connection pool. Queries are not cancelled
+// with the context; they run to completion as before.
+func (db *DB) WithContext(ctx context.Context) *DB {
+	return &DB{DB: db.DB, ctx: ctx}
+}
+
+// The query methods of the embedded *sql.DB are shadowed so every query is
+// timed, attributed to the DB method that issued it and traced. Queries
+// inside a transaction are not timed or traced individually; the transaction
+// is, from Begin to Commit or Rollback.
+
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.ExecContext(db.context(), query, args...)
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, done := startQuery(ctx, queryCaller(), query)
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.QueryContext(db.context(), query, args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, done := startQuery(ctx, queryCaller(), query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.QueryRowContext(db.context(), query, args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, done := startQuery(ctx, queryCaller(), query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+// Tx is a transaction begun with DB.Begin. Its duration is recorded like a
+// query of the DB method that began it.
+type Tx struct {
+	*sql.Tx
+	done func(error)
+}
+
+// Begin starts a transaction and starts timing it
+func (db *DB) Begin() (*Tx, error) {
+	_, done := startQuery(db.context(), queryCaller(), "")
+	tx, err := db.DB.BeginTx(db.context(), nil)
+	if err != nil {
+		done(err)
+		return nil, err
+	}
+	return &Tx{Tx: tx, done: done}, nil
+}
+
+// Commit commits the transaction and ends its timing
+func (tx *Tx) Commit() error {
+	err := tx.Tx.Commit()
+	tx.end(err)
+	return err
+}
+
+// Rollback aborts the transaction and ends its timing. Rolling back after a
+// commit, as a deferred Rollback does, changes nothing.
+func (tx *Tx) Rollback() error {
+	err := tx.Tx.Rollback()
+	if err != sql.ErrTxDone {
+		tx.end(err)
+	}
+	return err
+}
+
+func (tx *Tx) end(err error) {
+	if tx.done != nil {
+		tx.done(err)
+		tx.done = nil
+	}
+}
+
+// context returns the context of the request the database was bound to with
+// WithContext, without its cancellation
+func (db *DB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return context.WithoutCancel(db.ctx)
+}
+
+// startQuery starts timing a query or transaction issued by the DB method. Queries of a
+// traced request get a span; others, such as those run at startup, do not
+// start traces of their own. The returned function ends both.
+func startQuery(ctx context.Context, method, query string) (context.Context, func(error)) {
+	start := time.Now()
+
+	var span trace.Span
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		attributes := []attribute.KeyValue{semconv.DBSystemPostgreSQL, semconv.DBOperationName(method)}
+		// A transaction runs several queries, so it has no text of its own
+		if query != "" {
+			attributes = append(attributes, semconv.DBQueryText(strings.Join(strings.Fields(query), " ")))
+		}
+		ctx, span = tracing.Start(ctx, "db "+method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attributes...),
+		)
+	}
+
+	return ctx, func(err error) {
+		metrics.DBQueryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		if span == nil {
+			return
+		}
+		if err != nil && err != sql.ErrNoRows {
+			tracing.Fail(span, err)
+		}
+		span.End()
+	}
+}
+
+// dbMethodPrefix starts the function names of methods on *DB
+const dbMethodPrefix = "expensemanager/internal/database.(*DB)."
+
+// queryCaller names the DB method that ran a query. Helpers such as getUser
+// are called by the public method that matters, so the outermost DB method
+// on the stack wins; queries issued from other packages are labelled "other".
+func queryCaller() string {
+	pcs := make([]uintptr, 16)
+	// Skip runtime.Callers, queryCaller and the query method itself
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	method := "other"
+	for {
+		frame, more := frames.Next()
+		name, ok := strings.CutPrefix(frame.Function, dbMethodPrefix)
+		if !ok {
+			break
+		}
+		// Closures are named after the method that contains them
+		name, _, _ = strings.Cut(name, ".")
+		// The non-context query methods call their context variants
+		switch name {
+		case "Exec", "ExecContext", "Query", "QueryContext", "QueryRow", "QueryRowContext", "Begin":
+		default:
+			method = name
+		}
+		if !more {
+			break
+		}
+	}
+	return method
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+// loginAttemptRetention is how long failed sign-ins are kept
+const loginAttemptRetention = 90 * 24 * time.Hour
+
+// RecordLoginFailure logs a failed sign-in to the user's account and counts
+// it towards a lockout. Reaching models.MaxFailedLogins locks the account for
+// models.LockoutDuration and starts the count again. Attempts made while the
+// account is already locked are logged but do not extend the lockout.
+func (db *DB) RecordLoginFailure(userID int64, attempt *models.LoginAttempt, reason string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.Exec(`
+		INSERT INTO login_attempts (user_id, ip, user_agent, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, attempt.IP, attempt.UserAgent, reason, now)
+	if err != nil {
+		return err
+	}
+
+	if reason != models.LoginFailedLocked {
+		_, err = tx.Exec(`
+			UPDATE users
+			SET failed_login_count = CASE WHEN failed_login_count + 1 >= $1 THEN 0 ELSE failed_login_count + 1 END,
+				locked_until = CASE WHEN failed_login_count + 1 >= $1 THEN $2 ELSE locked_until END
+			WHERE id = $3
+		`, models.MaxFailedLogins, now.Add(models.LockoutDuration), userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM login_attempts WHERE user_id = $1 AND created_at < $2`, userID, now.Add(-loginAttemptRetention))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RecordLogin notes a successful sign-in and clears the failure count
+func (db *DB) RecordLogin(userID int64) error {
+	_, err := db.Exec(`
+		UPDATE users SET last_login_at = $1, failed_login_count = 0, locked_until = NULL
+		WHERE id = $2
+	`, time.Now(), userID)
+	return err
+}
+
+// GetLoginAttempts returns the user's most recent failed sign-ins, newest
+// first. A limit of zero returns all of them.
+func (db *DB) GetLoginAttempts(userID int64, limit int) ([]models.LoginAttempt, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, ip, user_agent, reason, created_at
+		FROM login_attempts
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT NULLIF($2, 0)
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var a models.LoginAttempt
+		err := rows.Scan(
+			&a.ID,
+			&a.UserID,
+			&a.IP,
+			&a.UserAgent,
+			&a.Reason,
+			&a.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"expensemanager/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// PromoteAdmins gives the administrator role to the accounts with the given
+// email addresses. Only verified addresses are promoted, so nobody can claim
+// the role by registering a listed address they do not own. It returns how
+// many accounts were promoted.
+func (db *DB) PromoteAdmins(emails []string) (int64, error) {
+	var normalized []string
+	for _, email := range emails {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			normalized = append(normalized, email)
+		}
+	}
+	if len(normalized) == 0 {
+		return 0, nil
+	}
+
+	result, err := db.Exec(`
+		UPDATE users
+		SET role = $1, updated_at = $2
+		WHERE LOWER(email) = ANY($3) AND email_verified_at IS NOT NULL AND role <> $1
+	`, models.RoleAdmin, time.Now(), pq.Array(normalized))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CountAdmins returns how many accounts have the administrator role
+func (db *DB) CountAdmins() (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = $1`, models.RoleAdmin).Scan(&count)
+	return count, err
+}
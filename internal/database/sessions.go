@@ -0,0 +1,158 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+// HashSessionToken returns the hash stored for a session cookie token, so a
+// copy of the database cannot be used to take over sessions
+func HashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession stores a new session under the given token hash
+func (db *DB) CreateSession(session *models.Session, tokenHash string) error {
+	now := time.Now()
+	err := db.QueryRow(`
+		INSERT INTO sessions (token_hash, user_id, data, ip, user_agent, created_at, last_seen_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6, $7)
+		RETURNING id
+	`, tokenHash, session.UserID, session.Data, session.IP, session.UserAgent, now, session.ExpiresAt).Scan(&session.ID)
+	if err != nil {
+		return err
+	}
+	session.CreatedAt = now
+	session.LastSeenAt = now
+	return nil
+}
+
+// DeleteExpiredSessions deletes the sessions that have expired and returns
+// how many there were
+func (db *DB) DeleteExpiredSessions() (int64, error) {
+	result, err := db.Exec(`DELETE FROM sessions WHERE expires_at <= $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetSessionByHash looks up a session by its token hash, returning nil if it
+// is unknown, revoked or expired
+func (db *DB) GetSessionByHash(tokenHash string) (*models.Session, error) {
+	s := &models.Session{}
+	err := db.QueryRow(`
+		SELECT id, user_id, data, ip, user_agent, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE token_hash = $1 AND expires_at > $2
+	`, tokenHash, time.Now()).Scan(
+		&s.ID,
+		&s.UserID,
+		&s.Data,
+		&s.IP,
+		&s.UserAgent,
+		&s.CreatedAt,
+		&s.LastSeenAt,
+		&s.ExpiresAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// UpdateSessionData saves the values of a live session. The update only
+// applies while the session still belongs to userID, so signing in or out
+// fails it and the caller starts a new session instead. It returns
+// sql.ErrNoRows if nothing was updated.
+func (db *DB) UpdateSessionData(tokenHash string, userID *int64, data []byte) error {
+	result, err := db.Exec(`
+		UPDATE sessions SET data = $1
+		WHERE token_hash = $2 AND user_id IS NOT DISTINCT FROM $3 AND expires_at > $4
+	`, data, tokenHash, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchSession records that a session was used just now, from where, and
+// when it will expire if it goes unused
+func (db *DB) TouchSession(id int64, ip, userAgent string, expiresAt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE sessions SET last_seen_at = $1, ip = $2, user_agent = $3, expires_at = $4
+		WHERE id = $5
+	`, time.Now(), ip, userAgent, expiresAt, id)
+	return err
+}
+
+// DeleteSessionByHash ends a session by its token hash
+func (db *DB) DeleteSessionByHash(tokenHash string) error {
+	_, err := db.Exec(`DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	return err
+}
+
+// GetSessions returns the user's live sessions, most recently used first.
+// The session with currentHash is marked as the current one.
+func (db *DB) GetSessions(userID int64, currentHash string) ([]models.Session, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, ip, user_agent, created_at, last_seen_at, expires_at, token_hash = $2
+		FROM sessions
+		WHERE user_id = $1 AND expires_at > $3
+		ORDER BY last_seen_at DESC
+	`, userID, currentHash, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var s models.Session
+		err := rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.IP,
+			&s.UserAgent,
+			&s.CreatedAt,
+			&s.LastSeenAt,
+			&s.ExpiresAt,
+			&s.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession signs one of the user's sessions out. It returns
+// sql.ErrNoRows if the session does not exist.
+func (db *DB) DeleteSession(userID, id int64) error {
+	result, err := db.Exec(`DELETE FROM sessions WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserSessions signs the user out of every session
+func (db *DB) DeleteUserSessions(userID int64) error {
+	_, err := db.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
+	return err
+}
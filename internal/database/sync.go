@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+// changedSince reports whether the server copy was modified after the
+// version the client based its change on. PostgreSQL keeps microseconds,
+// so differences below that are rounding, not edits.
+func changedSince(serverUpdatedAt, base time.Time) bool {
+	return serverUpdatedAt.Sub(base) >= time.Microsecond
+}
+
+// getExpenseByClientID loads and locks the expense with the given client UUID
+func getExpenseByClientID(tx *Tx, userID int64, clientID string) (*models.Expense, error) {
+	e := &models.Expense{}
+	err := tx.QueryRow(`
+		SELECT id, user_id, amount, description, category, date, created_at, updated_at, client_id::text
+		FROM expenses
+		WHERE user_id = $1 AND client_id = $2
+		FOR UPDATE
+	`, userID, clientID).Scan(
+		&e.ID,
+		&e.UserID,
+		&e.Amount,
+		&e.Description,
+		&e.Category,
+		&e.Date,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+		&e.ClientID,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SyncExpense idempotently applies one offline operation, identified by the
+// client-generated UUID, in its own transaction. base is the updated_at the
+// client last saw, or nil for an expense created offline. It returns the
+// resulting status and the server's current copy, which is nil once the
+// expense no longer exists.
+func (db *DB) SyncExpense(userID int64, clientID, action string, e *models.Expense, base *time.Time) (string, *models.Expense, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	current, err := getExpenseByClientID(tx, userID, clientID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+
+	switch action {
+	case models.SyncUpsert:
+		switch {
+		case current == nil && base != nil:
+			// The client edited an expense that was deleted on the server
+			return models.SyncConflict, nil, nil
+
+		case current == nil:
+			_, err = tx.Exec(`
+				INSERT INTO expenses (user_id, amount, description, category, date, created_at, updated_at, client_id)
+				VALUES ($1, $2, $3, $4, $5, $6, $6, $7)
+				ON CONFLICT (user_id, client_id) DO NOTHING
+			`, userID, e.Amount, e.Description, e.Category, e.Date, now, clientID)
+			if err != nil {
+				return "", nil, err
+			}
+
+		case base == nil:
+			// A replayed create that has already been applied
+
+		case changedSince(current.UpdatedAt, *base):
+			return models.SyncConflict, current, nil
+
+		default:
+			_, err = tx.Exec(`
+				UPDATE expenses
+				SET amount = $1, description = $2, category = $3, date = $4, updated_at = $5
+				WHERE id = $6
+			`, e.Amount, e.Description, e.Category, e.Date, now, current.ID)
+			if err != nil {
+				return "", nil, err
+			}
+		}
+
+		if current, err = getExpenseByClientID(tx, userID, clientID); err != nil {
+			return "", nil, err
+		}
+
+	case models.SyncDelete:
+		if current == nil {
+			return models.SyncApplied, nil, nil
+		}
+		if base != nil && changedSince(current.UpdatedAt, *base) {
+			return models.SyncConflict, current, nil
+		}
+		if _, err := tx.Exec("DELETE FROM expenses WHERE id = $1", current.ID); err != nil {
+			return "", nil, err
+		}
+		current = nil
+
+	default:
+		return models.SyncInvalid, current, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+	return models.SyncApplied, current, nil
+}
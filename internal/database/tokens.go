@@ -0,0 +1,127 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+// GenerateAPIToken returns a new random personal access token
+func GenerateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return models.APITokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashAPIToken returns the hash stored for a personal access token. Tokens
+// carry 256 bits of entropy, so a fast hash is sufficient and keeps lookups cheap.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken stores a new token for the user under the given hash
+func (db *DB) CreateAPIToken(token *models.APIToken, tokenHash string) error {
+	now := time.Now()
+	err := db.QueryRow(`
+		INSERT INTO api_tokens (user_id, name, token_hash, scope, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, token.UserID, token.Name, tokenHash, token.Scope, token.ExpiresAt, now).Scan(&token.ID)
+	if err != nil {
+		return err
+	}
+
+	token.CreatedAt = now
+	return nil
+}
+
+// GetAPITokenByHash looks up a token by its hash, returning nil if unknown
+func (db *DB) GetAPITokenByHash(tokenHash string) (*models.APIToken, error) {
+	t := &models.APIToken{}
+	err := db.QueryRow(`
+		SELECT id, user_id, name, scope, expires_at, last_used_at, created_at
+		FROM api_tokens
+		WHERE token_hash = $1
+	`, tokenHash).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.Scope,
+		&t.ExpiresAt,
+		&t.LastUsedAt,
+		&t.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetAPITokens returns all tokens belonging to the user, newest first
+func (db *DB) GetAPITokens(userID int64) ([]models.APIToken, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, name, scope, expires_at, last_used_at, created_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var t models.APIToken
+		err := rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.Name,
+			&t.Scope,
+			&t.ExpiresAt,
+			&t.LastUsedAt,
+			&t.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// TouchAPIToken records that the token has just been used
+func (db *DB) TouchAPIToken(tokenID int64) error {
+	_, err := db.Exec("UPDATE api_tokens SET last_used_at = $1 WHERE id = $2", time.Now(), tokenID)
+	return err
+}
+
+// DeleteAPIToken revokes one of the user's tokens
+func (db *DB) DeleteAPIToken(userID, tokenID int64) error {
+	result, err := db.Exec("DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", tokenID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
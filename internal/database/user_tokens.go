@@ -0,0 +1,194 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"expensemanager/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GenerateUserToken returns a new random token for an emailed link
+func GenerateUserToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashUserToken returns the hash stored for an emailed token
+func HashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateUserToken stores a new token for the user. Earlier unused tokens with
+// the same purpose are invalidated so only the most recent link works.
+func (db *DB) CreateUserToken(userID int64, purpose, tokenHash string, expiresAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.Exec(`
+		UPDATE user_tokens
+		SET used_at = $1
+		WHERE user_id = $2 AND purpose = $3 AND used_at IS NULL
+	`, now, userID, purpose)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, purpose, tokenHash, expiresAt, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetValidUserToken looks up an unused, unexpired token, returning nil otherwise
+func (db *DB) GetValidUserToken(purpose, tokenHash string) (*models.UserToken, error) {
+	t := &models.UserToken{}
+	err := db.QueryRow(`
+		SELECT id, user_id, purpose, expires_at, used_at, created_at
+		FROM user_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+	`, tokenHash, purpose, time.Now()).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Purpose,
+		&t.ExpiresAt,
+		&t.UsedAt,
+		&t.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ResetPassword consumes a password reset token and sets the new password.
+// Every existing session of the user is deleted and the session version
+// bumped, so all of them are signed out. It returns sql.ErrNoRows if the token is unknown, used or expired.
+func (db *DB) ResetPassword(tokenHash, password string) (int64, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int64
+	err = tx.QueryRow(`
+		UPDATE user_tokens
+		SET used_at = $1
+		WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id
+	`, now, tokenHash, models.TokenPurposePasswordReset).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET password = $1, must_reset_password = FALSE, session_version = session_version + 1, updated_at = $2
+		WHERE id = $3
+	`, string(hashedPassword), now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every session of the account is signed out
+	_, err = tx.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Any other outstanding reset links for the account stop working
+	_, err = tx.Exec(`
+		UPDATE user_tokens
+		SET used_at = $1
+		WHERE user_id = $2 AND purpose = $3 AND used_at IS NULL
+	`, now, userID, models.TokenPurposePasswordReset)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// CountRecentUserTokens returns how many tokens with the purpose were issued
+// to the user since the given time, and when the latest one was issued
+func (db *DB) CountRecentUserTokens(userID int64, purpose string, since time.Time) (int, *time.Time, error) {
+	var count int
+	var latest *time.Time
+	err := db.QueryRow(`
+		SELECT COUNT(*), MAX(created_at)
+		FROM user_tokens
+		WHERE user_id = $1 AND purpose = $2 AND created_at > $3
+	`, userID, purpose, since).Scan(&count, &latest)
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, latest, nil
+}
+
+// VerifyEmail consumes an email verification token and marks the user's
+// address as verified. It returns sql.ErrNoRows if the token is unknown,
+// used or expired.
+func (db *DB) VerifyEmail(tokenHash string) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID int64
+	err = tx.QueryRow(`
+		UPDATE user_tokens
+		SET used_at = $1
+		WHERE token_hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id
+	`, now, tokenHash, models.TokenPurposeEmailVerify).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, $1), updated_at = $1
+		WHERE id = $2
+	`, now, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,121 @@
+package events
+
+import (
+	"sync"
+)
+
+// Type identifies the kind of change an event describes
+type Type string
+
+const (
+	// ExpensesChanged is published whenever a user's expenses are added,
+	// edited or removed
+	ExpensesChanged Type = "expenses-changed"
+)
+
+// Event notifies a user's open sessions that their data changed
+type Event struct {
+	Type   Type   `json:"type"`
+	UserID int64  `json:"user_id"`
+	Month  string `json:"month,omitempty"` // YYYY-MM of the affected expense, if known
+}
+
+// Bus delivers events to the subscribers of the event's user
+type Bus interface {
+	// Publish sends an event to every subscriber of event.UserID
+	Publish(event Event) error
+	// Subscribe returns a channel receiving the user's events and a
+	// function that must be called to unsubscribe
+	Subscribe(userID int64) (<-chan Event, func())
+	// Close stops the bus and closes all subscriber channels
+	Close() error
+}
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before further events are dropped for it
+const subscriberBuffer = 16
+
+// MemoryBus is an in-process Bus. It only reaches subscribers connected to
+// the same server instance.
+type MemoryBus struct {
+	mu          sync.RWMutex
+	subscribers map[int64]map[chan Event]struct{}
+	closed      bool
+}
+
+// NewMemoryBus creates an empty in-process bus
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{
+		subscribers: make(map[int64]map[chan Event]struct{}),
+	}
+}
+
+// Publish delivers the event without blocking; subscribers whose buffer is
+// full miss it, which is harmless because every event triggers a full refresh
+func (b *MemoryBus) Publish(event Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a new subscriber for the user's events
+func (b *MemoryBus) Subscribe(userID int64) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan Event]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() { b.unsubscribe(userID, ch) })
+	}
+}
+
+// unsubscribe removes and closes a subscriber channel
+func (b *MemoryBus) unsubscribe(userID int64, ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[userID][ch]; !ok {
+		return
+	}
+	delete(b.subscribers[userID], ch)
+	if len(b.subscribers[userID]) == 0 {
+		delete(b.subscribers, userID)
+	}
+	close(ch)
+}
+
+// Close closes every subscriber channel and rejects new subscriptions
+func (b *MemoryBus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	for userID, subs := range b.subscribers {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(b.subscribers, userID)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresChannel is the LISTEN/NOTIFY channel used to share events
+const PostgresChannel = "expensemanager_events"
+
+// PostgresBus shares events between server instances through PostgreSQL
+// LISTEN/NOTIFY. Published events are sent to the database and fanned out
+// locally only when the notification comes back, so every instance,
+// including the publisher, delivers each event exactly once.
+type PostgresBus struct {
+	local    *MemoryBus
+	db       *sql.DB
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+// NewPostgresBus starts listening for events on a dedicated connection
+// opened with connStr; db is used to publish notifications
+func NewPostgresBus(db *sql.DB, connStr string) (*PostgresBus, error) {
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			slog.Warn("Event listener", "error", err)
+		}
+	})
+	if err := listener.Listen(PostgresChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", PostgresChannel, err)
+	}
+
+	b := &PostgresBus{
+		local:    NewMemoryBus(),
+		db:       db,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b, nil
+}
+
+// run forwards notifications to local subscribers until the listener closes
+func (b *PostgresBus) run() {
+	defer close(b.done)
+
+	for {
+		select {
+		case n, ok := <-b.listener.Notify:
+			if !ok {
+				return
+			}
+			// A nil notification signals a reconnect; events sent while
+			// disconnected are lost, which only delays the next refresh
+			if n == nil {
+				continue
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				slog.Warn("Event listener received an invalid payload", "payload", n.Extra, "error", err)
+				continue
+			}
+			b.local.Publish(event)
+
+		case <-time.After(90 * time.Second):
+			go b.listener.Ping()
+		}
+	}
+}
+
+// Publish sends the event to every instance via NOTIFY
+func (b *PostgresBus) Publish(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.Exec("SELECT pg_notify($1, $2)", PostgresChannel, string(payload))
+	return err
+}
+
+// Subscribe registers a subscriber on this instance
+func (b *PostgresBus) Subscribe(userID int64) (<-chan Event, func()) {
+	return b.local.Subscribe(userID)
+}
+
+// Close stops listening and closes all local subscribers
+func (b *PostgresBus) Close() error {
+	err := b.listener.Close()
+	<-b.done
+	b.local.Close()
+	return err
+}
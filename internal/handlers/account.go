@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"time"
+
+	"expensemanager/internal/database"
+	"expensemanager/internal/models"
+	"expensemanager/internal/passwords"
+)
+
+// accountOutcome describes one result of an account change
+type accountOutcome struct {
+	isError bool
+	// Form field the error is shown under; other results use the page banner
+	field string
+	// Password policy reason, whose message is shared with the other password forms
+	reason string
+}
+
+// accountResults are the outcomes of account changes, passed back to the
+// settings page in the "account" query parameter
+var accountResults = map[string]accountOutcome{
+	"profile_saved":      {},
+	"email_sent":         {},
+	"password_changed":   {},
+	"name_empty":         {isError: true, field: "name"},
+	"wrong_password":     {isError: true},
+	"too_many":           {isError: true},
+	"email_invalid":      {isError: true, field: "email"},
+	"email_same":         {isError: true, field: "email"},
+	"email_taken":        {isError: true, field: "email"},
+	"password_empty":     {isError: true, field: "password"},
+	"password_mismatch":  {isError: true, field: "confirm_password"},
+	"password_too_short": {isError: true, field: "password", reason: passwords.TooShort},
+	"password_too_long":  {isError: true, field: "password", reason: passwords.TooLong},
+	"password_common":    {isError: true, field: "password", reason: passwords.Common},
+	"password_breached":  {isError: true, field: "password", reason: passwords.Breached},
+	"confirm_delete":     {isError: true},
+	"last_admin":         {isError: true},
+	"sign_in_again":      {isError: true},
+	"last_sign_in":       {isError: true},
+}
+
+// accountResult shows the outcome of an account change named in the "account"
+// query parameter on the settings page
+func (h *Handler) accountResult(r *http.Request, data *TemplateData) {
+	result := r.URL.Query().Get("account")
+	outcome, ok := accountResults[result]
+	if !ok {
+		return
+	}
+
+	message := h.i18n.Translate(data.Lang, "settings.account.result."+result)
+	if outcome.reason != "" {
+		refused := &passwords.Error{Reason: outcome.reason, MinLength: data.PasswordMinLength}
+		message = passwordMessage(h.i18n, data.Lang, refused)
+	}
+
+	switch {
+	case !outcome.isError:
+		data.Success = message
+	case outcome.field != "":
+		data.FieldErrors[outcome.field] = message
+	default:
+		data.Error = message
+	}
+}
+
+// lastSignInMethod reports whether removing one passkey or linked identity
+// would leave the user, who has no password, without a way to sign in
+func (h *Handler) lastSignInMethod(ctx context.Context, userID int64) (bool, error) {
+	db := h.db.WithContext(ctx)
+	user, err := db.GetUserByID(userID)
+	if err != nil || user == nil || user.HasPassword() {
+		return false, err
+	}
+	passkeys, err := db.GetCredentials(userID)
+	if err != nil {
+		return false, err
+	}
+	identities, err := db.GetIdentities(userID)
+	if err != nil {
+		return false, err
+	}
+	return len(passkeys)+len(identities) <= 1, nil
+}
+
+// redirectAccount sends the user back to the account section of the settings
+// page with the outcome of their change
+func redirectAccount(w http.ResponseWriter, r *http.Request, result string) {
+	http.Redirect(w, r, "/settings?account="+result+"#account", http.StatusSeeOther)
+}
+
+// sendEmailChange mails a link confirming the user's new address to that address
+func (h *AuthHandler) sendEmailChange(ctx context.Context, user *models.User, email, lang string) error {
+	return h.sendTokenEmail(ctx, user, email, lang, models.TokenPurposeEmailChange, models.EmailVerifyTTL, "change_email", "/confirm-email")
+}
+
+// accountUser loads the signed-in user for a change that must be confirmed
+// with the current password. Wrong guesses are slowed down like failed
+// sign-ins. Accounts without a password confirm by having signed in again,
+// with an identity provider or a passkey, within models.ReauthWindow. When it
+// returns nil the response has already been written.
+func (h *AuthHandler) accountUser(w http.ResponseWriter, r *http.Request) *models.User {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	user, err := h.db.WithContext(r.Context()).GetUserByID(userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return nil
+	}
+
+	if !user.HasPassword() {
+		session, _ := h.store.Get(r, "session")
+		signedInAt, _ := session.Values["signed_in_at"].(int64)
+		if time.Since(time.Unix(signedInAt, 0)) > models.ReauthWindow {
+			redirectAccount(w, r, "sign_in_again")
+			return nil
+		}
+		return user
+	}
+
+	account := strings.ToLower(user.Email)
+	if h.accountBackoff.Wait(account) > 0 {
+		redirectAccount(w, r, "too_many")
+		return nil
+	}
+	if !database.CheckPassword(user, r.FormValue("current_password")) {
+		h.accountBackoff.Failure(account)
+		redirectAccount(w, r, "wrong_password")
+		return nil
+	}
+	return user
+}
+
+// HandleUpdateProfile changes the user's name
+func (h *AuthHandler) HandleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		redirectAccount(w, r, "name_empty")
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).UpdateUserName(userID, name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectAccount(w, r, "profile_saved")
+}
+
+// HandleChangeEmail starts changing the user's email address. The new address
+// only replaces the current one once the link mailed to it is opened, so a
+// typo cannot lock the user out.
+func (h *AuthHandler) HandleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	user := h.accountUser(w, r)
+	if user == nil {
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if address, err := netmail.ParseAddress(email); err != nil || address.Address != email {
+		redirectAccount(w, r, "email_invalid")
+		return
+	}
+	if strings.EqualFold(email, user.Email) {
+		redirectAccount(w, r, "email_same")
+		return
+	}
+
+	existing, err := h.db.WithContext(r.Context()).GetUserByEmail(email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		redirectAccount(w, r, "email_taken")
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).SetPendingEmail(user.ID, email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.sendEmailChange(r.Context(), user, email, h.GetTemplateData(r).Lang); err != nil {
+		slog.ErrorContext(r.Context(), "Error sending email change link", "error", err)
+		http.Error(w, "Failed to send confirmation email", http.StatusInternalServerError)
+		return
+	}
+	redirectAccount(w, r, "email_sent")
+}
+
+// HandleConfirmEmail completes an email change with the token from the link
+// mailed to the new address
+func (h *AuthHandler) HandleConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	data := h.GetTemplateData(r)
+
+	// The link may be opened on a device that is not signed in
+	_, err := h.db.WithContext(r.Context()).ConfirmEmailChange(database.HashUserToken(r.URL.Query().Get("token")))
+	switch err {
+	case nil:
+		data.Success = h.i18n.Translate(data.Lang, "auth.email_change.success")
+		h.promoteAdmins(r.Context())
+	case sql.ErrNoRows:
+		data.Error = h.i18n.Translate(data.Lang, "auth.verify.invalid")
+	case database.ErrEmailTaken:
+		data.Error = h.i18n.Translate(data.Lang, "settings.account.result.email_taken")
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data.User, err = h.sessionUser(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.renderAuth(w, r, "verify-email", data)
+}
+
+// HandleChangePassword sets a new password after checking the current one.
+// Every other session is signed out; this one stays signed in.
+func (h *AuthHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	user := h.accountUser(w, r)
+	if user == nil {
+		return
+	}
+
+	password := r.FormValue("password")
+	if password == "" {
+		redirectAccount(w, r, "password_empty")
+		return
+	}
+	if refused := checkNewPassword(h.passwordPolicy, password); refused != nil {
+		redirectAccount(w, r, "password_"+refused.Reason)
+		return
+	}
+	if password != r.FormValue("confirm_password") {
+		redirectAccount(w, r, "password_mismatch")
+		return
+	}
+
+	session, _ := h.store.Get(r, "session")
+	version, err := h.db.WithContext(r.Context()).ChangePassword(user.ID, password, database.HashSessionToken(session.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	session.Values["session_version"] = version
+	if err := session.Save(r, w); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving session", "error", err)
+	}
+
+	redirectAccount(w, r, "password_changed")
+}
+
+// HandleDeleteAccount deletes the user's account with all of its data after
+// the user confirms with their password
+func (h *AuthHandler) HandleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := h.accountUser(w, r)
+	if user == nil {
+		return
+	}
+
+	if r.FormValue("confirm") != "on" {
+		redirectAccount(w, r, "confirm_delete")
+		return
+	}
+
+	// The instance must keep at least one administrator
+	if user.IsAdmin() {
+		admins, err := h.db.WithContext(r.Context()).CountAdmins()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if admins <= 1 {
+			redirectAccount(w, r, "last_admin")
+			return
+		}
+	}
+
+	if err := h.db.WithContext(r.Context()).DeleteUser(user.ID); err != nil && err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "User deleted their account")
+
+	session, _ := h.store.Get(r, "session")
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		slog.ErrorContext(r.Context(), "Error clearing session", "error", err)
+	}
+
+	http.Redirect(w, r, "/login?account=deleted", http.StatusSeeOther)
+}
+
+// HandleExportAccount downloads a zip archive of everything stored about the
+// user: account.json with the account, expenses, tokens, passkeys, sessions
+// and failed sign-ins, and the expenses again as expenses.csv
+func (h *Handler) HandleExportAccount(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	export, err := h.accountExport(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("expensemanager-%s.zip", export.ExportedAt.Format(models.DateFormat))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	archive := zip.NewWriter(w)
+	if err := writeAccountArchive(archive, export); err != nil {
+		// Headers are already sent, so the download is left truncated
+		slog.ErrorContext(r.Context(), "Error writing account export", "error", err)
+		return
+	}
+	if err := archive.Close(); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing account export", "error", err)
+	}
+}
+
+// accountExport gathers the user's personal data
+func (h *Handler) accountExport(r *http.Request, userID int64) (*models.AccountExport, error) {
+	db := h.db.WithContext(r.Context())
+	export := &models.AccountExport{ExportedAt: time.Now()}
+
+	var err error
+	export.Account, err = db.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if export.Account == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	expenses, err := db.GetExpenses(userID)
+	if err != nil {
+		return nil, err
+	}
+	export.Expenses = make([]models.ExpenseJSON, len(expenses))
+	for i, e := range expenses {
+		export.Expenses[i] = models.NewExpenseJSON(e)
+	}
+
+	if export.APITokens, err = db.GetAPITokens(userID); err != nil {
+		return nil, err
+	}
+	if export.Passkeys, err = db.GetCredentials(userID); err != nil {
+		return nil, err
+	}
+	if export.Identities, err = db.GetIdentities(userID); err != nil {
+		return nil, err
+	}
+	session, _ := h.store.Get(r, "session")
+	if export.Sessions, err = db.GetSessions(userID, database.HashSessionToken(session.ID)); err != nil {
+		return nil, err
+	}
+	if export.LoginAttempts, err = db.GetLoginAttempts(userID, 0); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// writeAccountArchive adds account.json and expenses.csv to the archive
+func writeAccountArchive(archive *zip.Writer, export *models.AccountExport) error {
+	header := &zip.FileHeader{Name: "account.json", Method: zip.Deflate, Modified: export.ExportedAt}
+	f, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(export); err != nil {
+		return err
+	}
+
+	header = &zip.FileHeader{Name: "expenses.csv", Method: zip.Deflate, Modified: export.ExportedAt}
+	f, err = archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	return models.WriteExpensesCSV(f, export.Expenses)
+}
@@ -1,164 +1,280 @@
 package handlers
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
+	"database/sql"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	"time"
 
+	"expensemanager/internal/database"
 	"expensemanager/internal/models"
+	"expensemanager/internal/tracing"
+
+	"github.com/gorilla/sessions"
+)
+
+// Page sizes of the administration tables
+const (
+	adminUsersPageSize = 25
+	adminAuditPageSize = 50
 )
 
-type UploadResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+// Pagination describes which page of a long list is shown
+type Pagination struct {
+	Page     int
+	PageSize int
+	Total    int
+}
+
+// newPagination reads the 1-based page number from the "page" query parameter
+func newPagination(r *http.Request, pageSize int) Pagination {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	return Pagination{Page: page, PageSize: pageSize}
+}
+
+// Offset returns how many items come before the page
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// Pages returns the number of pages, at least one
+func (p Pagination) Pages() int {
+	if p.Total == 0 {
+		return 1
+	}
+	return (p.Total + p.PageSize - 1) / p.PageSize
+}
+
+// HasPrev reports whether there is a page before this one
+func (p Pagination) HasPrev() bool {
+	return p.Page > 1
+}
+
+// HasNext reports whether there is a page after this one
+func (p Pagination) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+// Prev returns the previous page number
+func (p Pagination) Prev() int {
+	return p.Page - 1
 }
 
+// Next returns the next page number
+func (p Pagination) Next() int {
+	return p.Page + 1
+}
+
+// HandleAdmin renders the instance administration page: storage usage and a
+// searchable, paginated list of accounts. The route is limited to
+// administrators with RequireRole, like every other /admin route.
 func (h *Handler) HandleAdmin(w http.ResponseWriter, r *http.Request) {
 	// Get base template data
 	data := h.GetTemplateData(r)
+	data.Search = strings.TrimSpace(r.URL.Query().Get("q"))
+	data.Pagination = newPagination(r, adminUsersPageSize)
 
-	if err := h.tmpl.ExecuteTemplate(w, "admin", data); err != nil {
+	users, total, err := h.db.WithContext(r.Context()).ListUsers(data.Search, data.Pagination.PageSize, data.Pagination.Offset())
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-}
+	data.AdminUsers = users
+	data.Pagination.Total = total
 
-func (h *Handler) HandleClearExpenses(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	data.Storage, err = h.db.WithContext(r.Context()).GetStorageUsage()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Get user ID from context
-	userID, _ := GetUserIDFromContext(r.Context())
-
-	if err := h.db.ClearExpenses(userID); err != nil {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "admin", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+}
+
+// HandleAdminAudit renders the paginated audit log of administrator actions
+func (h *Handler) HandleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	data := h.GetTemplateData(r)
+	data.Pagination = newPagination(r, adminAuditPageSize)
 
-	expenses, err := h.db.GetExpenses(userID)
+	entries, total, err := h.db.WithContext(r.Context()).GetAuditLog(data.Pagination.PageSize, data.Pagination.Offset())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	data.AuditLog = entries
+	data.Pagination.Total = total
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "admin-audit", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("HX-Trigger", "updateSummary")
-	h.tmpl.ExecuteTemplate(w, "expenses-table.html", expenses)
+// HandleAdminDisableUser blocks an account and signs it out everywhere
+func (h *Handler) HandleAdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, true)
 }
 
-func (h *Handler) HandleDownloadExpenses(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from context
-	userID, _ := GetUserIDFromContext(r.Context())
+// HandleAdminEnableUser lets a disabled account sign in again
+func (h *Handler) HandleAdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserDisabled(w, r, false)
+}
 
-	expenses, err := h.db.GetExpenses(userID)
-	if err != nil {
+func (h *Handler) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	target, ok := adminTarget(h.db.WithContext(r.Context()), w, r, disabled)
+	if !ok {
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).SetUserDisabled(target.ID, disabled); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	expensesJSON := make([]models.ExpenseJSON, len(expenses))
-	for i, e := range expenses {
-		expensesJSON[i] = models.ExpenseJSON{
-			ID:          e.ID,
-			UserID:      e.UserID,
-			Amount:      e.Amount,
-			Description: e.Description,
-			Category:    e.Category,
-			Date:        e.Date.Format("2006-01-02"),
-			CreatedAt:   e.CreatedAt.Format("2006-01-02 15:04:05"),
-		}
+	action := models.AuditEnableUser
+	if disabled {
+		action = models.AuditDisableUser
+	}
+	recordAudit(h.db.WithContext(r.Context()), h.store, r, action, target, "")
+	http.Redirect(w, r, adminReturnURL(r), http.StatusSeeOther)
+}
+
+// HandleAdminResetTOTP turns off two-factor authentication for a user who
+// lost their device and recovery codes
+func (h *Handler) HandleAdminResetTOTP(w http.ResponseWriter, r *http.Request) {
+	target, ok := adminTarget(h.db.WithContext(r.Context()), w, r, false)
+	if !ok {
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).DisableTOTP(target.ID); err != nil && err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Disposition", "attachment; filename=expenses.json")
-	json.NewEncoder(w).Encode(expensesJSON)
+	recordAudit(h.db.WithContext(r.Context()), h.store, r, models.AuditResetTOTP, target, "")
+	http.Redirect(w, r, adminReturnURL(r), http.StatusSeeOther)
 }
 
-func (h *Handler) HandleUploadExpenses(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// HandleAdminDeleteUser deletes an account with all of its data
+func (h *Handler) HandleAdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	target, ok := adminTarget(h.db.WithContext(r.Context()), w, r, true)
+	if !ok {
 		return
 	}
 
-	// Get user ID from context
-	userID, _ := GetUserIDFromContext(r.Context())
+	if err := h.db.WithContext(r.Context()).DeleteUser(target.ID); err != nil && err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	// Parse multipart form
-	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
-		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+	recordAudit(h.db.WithContext(r.Context()), h.store, r, models.AuditDeleteUser, target, "")
+	http.Redirect(w, r, adminReturnURL(r), http.StatusSeeOther)
+}
+
+// HandleAdminForcePasswordReset invalidates a user's password, signs them out
+// everywhere and emails them a link to choose a new one
+func (h *AuthHandler) HandleAdminForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	target, ok := adminTarget(h.db.WithContext(r.Context()), w, r, false)
+	if !ok {
 		return
 	}
 
-	// Get file from form
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		http.Error(w, "Failed to get file", http.StatusBadRequest)
+	if err := h.db.WithContext(r.Context()).InvalidatePassword(target.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer file.Close()
 
-	// Read file contents
-	fileBytes, err := io.ReadAll(file)
+	details := ""
+	if err := h.sendPasswordReset(r.Context(), target, h.GetTemplateData(r).Lang); err != nil {
+		slog.ErrorContext(r.Context(), "Error sending forced password reset", "target_user_id", target.ID, "error", err)
+		details = "reset email could not be sent"
+	}
+
+	recordAudit(h.db.WithContext(r.Context()), h.store, r, models.AuditForceReset, target, details)
+	http.Redirect(w, r, adminReturnURL(r), http.StatusSeeOther)
+}
+
+// adminTarget loads the user named by the {id} path value of an administrator
+// action. Actions that would lock the administrator out of their own account
+// are refused when notSelf is set. When it returns false the response has
+// already been written.
+func adminTarget(db *database.DB, w http.ResponseWriter, r *http.Request, notSelf bool) (*models.User, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
 	if err != nil {
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
-		return
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+	if notSelf && id == userID {
+		http.Error(w, "You cannot do this to your own account", http.StatusBadRequest)
+		return nil, false
+	}
+
+	user, err := db.GetUserByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if user == nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
 	}
+	return user, true
+}
+
+// recordAudit writes an administrator's action to the audit log. Failing to
+// record it is logged but does not undo the action.
+func recordAudit(db *database.DB, store sessions.Store, r *http.Request, action string, target *models.User, details string) {
+	// Get user ID from context
+	actorID, _ := GetUserIDFromContext(r.Context())
+	session, _ := store.Get(r, "session")
+	actorEmail, _ := session.Values["user_email"].(string)
 
-	// Parse JSON
-	var expenses []models.ExpenseJSON
-	if err := json.Unmarshal(fileBytes, &expenses); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	entry := &models.AuditEntry{
+		ActorID:      &actorID,
+		ActorEmail:   actorEmail,
+		Action:       action,
+		TargetUserID: &target.ID,
+		TargetEmail:  target.Email,
+		Details:      details,
+	}
+	if err := db.AddAuditEntry(entry); err != nil {
+		slog.ErrorContext(r.Context(), "Error recording audit entry", "action", action, "target_user_id", target.ID, "error", err)
 		return
 	}
+	slog.InfoContext(r.Context(), "Admin action", "action", action, "actor_email", actorEmail, "target_email", target.Email)
+}
 
-	// Validate and add expenses
-	for _, e := range expenses {
-		// Parse date
-		date, err := time.Parse("2006-01-02", e.Date)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid date format for expense %d", e.ID), http.StatusBadRequest)
-			return
-		}
-
-		// Validate category
-		validCategory := false
-		for _, cat := range models.Categories() {
-			if strings.EqualFold(e.Category, cat) {
-				validCategory = true
-				break
-			}
-		}
-		if !validCategory {
-			http.Error(w, fmt.Sprintf("Invalid category for expense %d", e.ID), http.StatusBadRequest)
-			return
-		}
-
-		// Create expense
-		expense := &models.Expense{
-			UserID:      userID,
-			Amount:      e.Amount,
-			Description: e.Description,
-			Category:    e.Category,
-			Date:        date,
-		}
-
-		if err := h.db.AddExpense(expense); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to add expense %d", e.ID), http.StatusInternalServerError)
-			return
-		}
-	}
-
-	// Return success response
-	response := UploadResponse{
-		Success: true,
-		Message: fmt.Sprintf("Successfully uploaded %d expenses", len(expenses)),
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// adminReturnURL sends the administrator back to the page and search of the
+// users list the action was taken from
+func adminReturnURL(r *http.Request) string {
+	query := url.Values{}
+	if page := r.FormValue("page"); page != "" {
+		query.Set("page", page)
+	}
+	if search := r.FormValue("q"); search != "" {
+		query.Set("q", search)
+	}
+	if len(query) == 0 {
+		return "/admin"
+	}
+	return "/admin?" + query.Encode()
 }
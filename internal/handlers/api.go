@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"expensemanager/internal/metrics"
+	"expensemanager/internal/models"
+)
+
+// maxImportSize bounds the body accepted by the import endpoint
+const maxImportSize = 10 << 20 // 10 MB
+
+// writeJSON encodes v as the JSON response body with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// HandleAPIExpenses lists expenses (GET) or creates one (POST)
+func (h *Handler) HandleAPIExpenses(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		expenses, err := h.expensesForQuery(r.Context(), userID, r.URL.Query().Get("month"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result := make([]models.ExpenseJSON, len(expenses))
+		for i, e := range expenses {
+			result[i] = models.NewExpenseJSON(e)
+		}
+		writeJSON(w, http.StatusOK, result)
+
+	case http.MethodPost:
+		var payload models.ExpenseJSON
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		expense, err := payload.ToExpense(userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := h.db.WithContext(r.Context()).AddExpense(&expense); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		metrics.ExpensesCreated.WithLabelValues(metrics.SourceAPI).Inc()
+		h.publishExpensesChanged(r.Context(), userID, expense.Date)
+
+		w.Header().Set("Location", fmt.Sprintf("/api/expenses/%d", expense.ID))
+		writeJSON(w, http.StatusCreated, models.NewExpenseJSON(expense))
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// expensesForQuery returns the expenses of one month (YYYY-MM) or all of them
+func (h *Handler) expensesForQuery(ctx context.Context, userID int64, month string) ([]models.Expense, error) {
+	if month == "" {
+		return h.db.WithContext(ctx).GetExpenses(userID)
+	}
+
+	monthDate, err := time.Parse(models.MonthFormat, month)
+	if err != nil {
+		return nil, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+	return h.db.WithContext(ctx).GetExpensesByMonth(userID, monthDate.Year(), int(monthDate.Month()))
+}
+
+// HandleAPIExpense reads (GET), replaces (PUT) or deletes (DELETE) one expense
+func (h *Handler) HandleAPIExpense(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	expenseID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid expense ID", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		expense, err := h.db.WithContext(r.Context()).GetExpense(userID, expenseID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if expense == nil {
+			http.Error(w, "Expense not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, models.NewExpenseJSON(*expense))
+
+	case http.MethodPut:
+		var payload models.ExpenseJSON
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		expense, err := payload.ToExpense(userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		expense.ID = expenseID
+
+		if err := h.db.WithContext(r.Context()).UpdateExpense(&expense); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Expense not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.publishExpensesChanged(r.Context(), userID, expense.Date)
+
+		updated, err := h.db.WithContext(r.Context()).GetExpense(userID, expenseID)
+		if err != nil || updated == nil {
+			http.Error(w, "Failed to reload expense", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, models.NewExpenseJSON(*updated))
+
+	case http.MethodDelete:
+		if err := h.db.WithContext(r.Context()).DeleteExpense(userID, expenseID); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Expense not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.publishExpensesChanged(r.Context(), userID, time.Time{})
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleAPIImport adds a batch of expenses from a CSV or JSON body. Either
+// all expenses are imported or, if any row is invalid, none are.
+func (h *Handler) HandleAPIImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	result := metrics.ResultFailure
+	defer func() {
+		metrics.ImportsRun.WithLabelValues(metrics.SourceAPIImport, result).Inc()
+	}()
+
+	body := io.LimitReader(r.Body, maxImportSize)
+
+	var payload []models.ExpenseJSON
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		payload, err = models.ReadExpensesCSV(body)
+	} else {
+		err = json.NewDecoder(body).Decode(&payload)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid import file: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	expenses := make([]models.Expense, len(payload))
+	for i, p := range payload {
+		expenses[i], err = p.ToExpense(userID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Expense %d: %v", i+1, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.db.WithContext(r.Context()).ImportExpenses(expenses); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result = metrics.ResultSuccess
+	metrics.ExpensesCreated.WithLabelValues(metrics.SourceAPIImport).Add(float64(len(expenses)))
+	if len(expenses) > 0 {
+		h.publishExpensesChanged(r.Context(), userID, time.Time{})
+	}
+
+	writeJSON(w, http.StatusOK, models.ImportResult{Imported: len(expenses)})
+}
+
+// HandleAPIExport returns all of the user's expenses as CSV or JSON
+func (h *Handler) HandleAPIExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "Unsupported format, use csv or json", http.StatusBadRequest)
+		return
+	}
+
+	expenses, err := h.db.WithContext(r.Context()).GetExpenses(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]models.ExpenseJSON, len(expenses))
+	for i, e := range expenses {
+		result[i] = models.NewExpenseJSON(e)
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=expenses.csv")
+		models.WriteExpensesCSV(w, result)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=expenses.json")
+	writeJSON(w, http.StatusOK, result)
+}
+
+// HandleAPIYearlyReport returns monthly and category totals for a year
+func (h *Handler) HandleAPIYearlyReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	year := time.Now().Year()
+	if value := r.URL.Query().Get("year"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid year", http.StatusBadRequest)
+			return
+		}
+		year = parsed
+	}
+
+	report, err := h.db.WithContext(r.Context()).GetYearlyReport(userID, year)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
@@ -4,11 +4,26 @@ import (
 	"context"
 	"expensemanager/internal/database"
 	"expensemanager/internal/i18n"
+	"expensemanager/internal/logging"
+	"expensemanager/internal/mail"
+	"expensemanager/internal/metrics"
+	"expensemanager/internal/middleware"
 	"expensemanager/internal/models"
+	"expensemanager/internal/passwords"
+	"expensemanager/internal/ratelimit"
+	"expensemanager/internal/sso"
+	"expensemanager/internal/tracing"
 	"html/template"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gorilla/sessions"
 )
 
@@ -18,20 +33,58 @@ type AuthTemplateData struct {
 	AvailableLanguages []string
 	Error              string
 	User               *models.User
+	CSRFToken          string
+	Success            string
+	Token              string
+	// Errors shown under the form field they belong to, by field name
+	FieldErrors map[string]string
+	// Values kept in the register form when it is shown again with errors
+	Name  string
+	Email string
+	// Shortest password the policy accepts
+	PasswordMinLength int
+	// Identity providers offered on the sign-in page
+	SSOProviders []*sso.Provider
 }
 
 type AuthHandler struct {
-	db    *database.DB
-	tmpl  *template.Template
-	store sessions.Store
-	i18n  *i18n.Manager
+	db            *database.DB
+	tmpl          *template.Template
+	store         sessions.Store
+	i18n          *i18n.Manager
+	mailer        mail.Mailer
+	mailTemplates *mail.Templates
+	baseURL       string
+	webauthn      *webauthn.WebAuthn
+	// What accounts with an unverified email may do
+	unverifiedPolicy string
+	// Slow down password guessing from one address or against one account
+	ipBackoff      *ratelimit.Backoff
+	accountBackoff *ratelimit.Backoff
+	// Slow down password reset requests from one address
+	resetBackoff *ratelimit.Backoff
+	// Whether X-Forwarded-For from a reverse proxy names the client
+	trustProxy bool
+	// Addresses promoted to administrator once verified
+	adminEmails []string
+	// Rules new passwords must follow
+	passwordPolicy *passwords.Policy
+	// OpenID Connect identity providers users can sign in with
+	ssoProviders []*sso.Provider
+	// Work requests leave running, such as sending email
+	background sync.WaitGroup
 }
 
 func NewAuthHandler(db *database.DB, tmpl *template.Template, store sessions.Store) *AuthHandler {
 	return &AuthHandler{
-		db:    db,
-		tmpl:  tmpl,
-		store: store,
+		db:               db,
+		tmpl:             tmpl,
+		store:            store,
+		unverifiedPolicy: models.UnverifiedReadOnly,
+		// Addresses get more free attempts since many users may share one
+		ipBackoff:      ratelimit.NewBackoff(10, time.Second, 10*time.Minute, time.Hour),
+		accountBackoff: ratelimit.NewBackoff(3, time.Second, 10*time.Minute, time.Hour),
+		resetBackoff:   ratelimit.NewBackoff(5, time.Minute, time.Hour, 24*time.Hour),
 	}
 }
 
@@ -40,11 +93,68 @@ func (h *AuthHandler) UpdateI18n(manager *i18n.Manager) {
 	h.i18n = manager
 }
 
+// UpdateMailer sets the mailer, the email templates and the public base URL
+// used to build links in emails
+func (h *AuthHandler) UpdateMailer(mailer mail.Mailer, templates *mail.Templates, baseURL string) {
+	h.mailer = mailer
+	h.mailTemplates = templates
+	h.baseURL = strings.TrimRight(baseURL, "/")
+}
+
+// UpdateWebAuthn sets the relying party used for passkey registration and
+// sign-in
+func (h *AuthHandler) UpdateWebAuthn(relyingParty *webauthn.WebAuthn) {
+	h.webauthn = relyingParty
+}
+
+// UpdateUnverifiedPolicy sets what accounts with an unverified email may do
+func (h *AuthHandler) UpdateUnverifiedPolicy(policy string) {
+	h.unverifiedPolicy = policy
+}
+
+// UpdateAdminEmails sets the addresses whose accounts become administrators
+// once the address is verified
+func (h *AuthHandler) UpdateAdminEmails(emails []string) {
+	h.adminEmails = emails
+}
+
+// UpdateTrustProxy sets whether the client address is taken from the
+// X-Forwarded-For header. Only enable it behind a proxy that sets the header.
+func (h *AuthHandler) UpdateTrustProxy(trust bool) {
+	h.trustProxy = trust
+}
+
+// UpdatePasswordPolicy sets the rules new passwords must follow
+func (h *AuthHandler) UpdatePasswordPolicy(policy *passwords.Policy) {
+	h.passwordPolicy = policy
+}
+
+// Drain waits for work that requests left running in the background, such
+// as sending email, to finish. It gives up when ctx is done.
+func (h *AuthHandler) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // GetTemplateData prepares common template data
 func (h *AuthHandler) GetTemplateData(r *http.Request) *AuthTemplateData {
 	data := &AuthTemplateData{
 		Lang:               "en",                           // Default language
 		AvailableLanguages: h.i18n.GetAvailableLanguages(), // Get available languages from i18n manager
+		CSRFToken:          middleware.CSRFToken(r.Context()),
+		FieldErrors:        make(map[string]string),
+		PasswordMinLength:  passwordMinLength(h.passwordPolicy),
+		SSOProviders:       h.ssoProviders,
 	}
 
 	// Get language from session if available
@@ -58,7 +168,10 @@ func (h *AuthHandler) GetTemplateData(r *http.Request) *AuthTemplateData {
 
 type userIDKey struct{}
 
+// SetUserIDContext records the signed-in user in the context and in the
+// request's log records
 func SetUserIDContext(ctx context.Context, userID int64) context.Context {
+	logging.SetUserID(ctx, userID)
 	return context.WithValue(ctx, userIDKey{}, userID)
 }
 
@@ -69,6 +182,12 @@ func GetUserIDFromContext(ctx context.Context) (int64, bool) {
 
 func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	data := h.GetTemplateData(r)
+	if r.URL.Query().Get("reset") == "done" {
+		data.Success = h.i18n.Translate(data.Lang, "auth.reset.success")
+	}
+	if r.URL.Query().Get("account") == "deleted" {
+		data.Success = h.i18n.Translate(data.Lang, "auth.account_deleted")
+	}
 
 	if r.Method == http.MethodPost {
 		form := &models.LoginForm{
@@ -77,62 +196,169 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 			Language: r.FormValue("language"),
 		}
 
-		user, err := h.db.AuthenticateUser(form.Email, form.Password)
+		ip := h.ClientIP(r)
+		account := strings.ToLower(strings.TrimSpace(form.Email))
+
+		// Repeated failures from this address or against this account must
+		// wait longer and longer before the password is even checked
+		if wait := max(h.ipBackoff.Wait(ip), h.accountBackoff.Wait(account)); wait > 0 {
+			metrics.LoginFailures.WithLabelValues("throttled").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			data.Error = h.i18n.Translate(data.Lang, "auth.login.too_many")
+			h.renderAuth(w, r, "login", data)
+			return
+		}
+
+		user, err := h.db.WithContext(r.Context()).AuthenticateUser(form.Email, form.Password, &models.LoginAttempt{
+			IP:        ip,
+			UserAgent: r.UserAgent(),
+		})
 		if err != nil {
-			data.Error = "Invalid email or password"
-			if err := h.tmpl.ExecuteTemplate(w, "login", data); err != nil {
-				log.Printf("Error executing login template: %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			reason := "error"
+			switch err {
+			case database.ErrInvalidCredentials:
+				reason = models.LoginFailedPassword
+				data.Error = h.i18n.Translate(data.Lang, "auth.login.invalid")
+			case database.ErrAccountLocked:
+				// A locked account answers like a wrong password, or the
+				// lockout would tell which emails have accounts; the attempts
+				// log and metrics still record why
+				reason = models.LoginFailedLocked
+				data.Error = h.i18n.Translate(data.Lang, "auth.login.invalid")
+			case database.ErrAccountDisabled:
+				reason = "disabled"
+				data.Error = h.i18n.Translate(data.Lang, "auth.login.disabled")
+			default:
+				slog.ErrorContext(r.Context(), "Error authenticating user", "error", err)
+				data.Error = h.i18n.Translate(data.Lang, "errors.generic")
 			}
+			metrics.LoginFailures.WithLabelValues(reason).Inc()
+			h.ipBackoff.Failure(ip)
+			h.accountBackoff.Failure(account)
+			h.renderAuth(w, r, "login", data)
 			return
 		}
+		h.accountBackoff.Success(account)
+
+		h.completeLogin(w, r, user, form.Language)
+		return
+	}
+
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "login", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing login template", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// completeLogin signs the user in after their first factor. Accounts with
+// two-factor authentication finish signing in on the second step; until then
+// the session only remembers who is pending.
+func (h *AuthHandler) completeLogin(w http.ResponseWriter, r *http.Request, user *models.User, language string) {
+	// An administrator forced a reset: not even another way of signing in
+	// counts until the user has chosen a new password
+	if user.MustResetPassword {
+		data := h.GetTemplateData(r)
+		data.Error = h.i18n.Translate(data.Lang, "auth.login.reset_required")
+		h.renderAuth(w, r, "login", data)
+		return
+	}
 
-		// Set session
+	if user.TOTPEnabled() {
 		session, _ := h.store.Get(r, "session")
-		session.Values["user_id"] = user.ID
-		session.Values["user_email"] = user.Email
-		session.Values["user_name"] = user.Name
-		session.Values["language"] = form.Language
+		session.Values["pending_user_id"] = user.ID
+		session.Values["pending_language"] = language
+		session.Values["pending_at"] = time.Now().Unix()
+		session.Values["pending_attempts"] = 0
 		session.Save(r, w)
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
 		return
 	}
 
-	if err := h.tmpl.ExecuteTemplate(w, "login", data); err != nil {
-		log.Printf("Error executing login template: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	h.startSession(w, r, user, language)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// startSession signs the user in to the browser session
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user *models.User, language string) {
+	session, _ := h.store.Get(r, "session")
+	delete(session.Values, "pending_user_id")
+	delete(session.Values, "pending_language")
+	delete(session.Values, "pending_at")
+	delete(session.Values, "pending_attempts")
+	session.Values["user_id"] = user.ID
+	session.Values["user_email"] = user.Email
+	session.Values["user_name"] = user.Name
+	session.Values["user_role"] = user.Role
+	session.Values["session_version"] = user.SessionVersion
+	session.Values["email_verified"] = user.EmailVerified()
+	session.Values["language"] = language
+	session.Values["signed_in_at"] = time.Now().Unix()
+	middleware.ResetCSRFToken(session)
+	session.Save(r, w)
+
+	if err := h.db.WithContext(r.Context()).RecordLogin(user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "Error recording login", "user_id", user.ID, "error", err)
 	}
 }
 
+// ClientIP returns the address of the client making the request
+func (h *AuthHandler) ClientIP(r *http.Request) string {
+	if h.trustProxy {
+		// The proxy appends the address it saw, so the last entry is the one
+		// a client cannot forge
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			parts := strings.Split(forwarded, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func (h *AuthHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	data := h.GetTemplateData(r)
 
 	if r.Method == http.MethodPost {
 		form := &models.RegisterForm{
-			Email:           r.FormValue("email"),
+			Email:           strings.TrimSpace(r.FormValue("email")),
 			Password:        r.FormValue("password"),
 			ConfirmPassword: r.FormValue("confirm_password"),
 			Name:            r.FormValue("name"),
 		}
 
 		// Validate form
-		if form.Password != form.ConfirmPassword {
-			data.Error = "Passwords do not match"
-			h.tmpl.ExecuteTemplate(w, "register", data)
+		if address, err := netmail.ParseAddress(form.Email); err != nil || address.Address != form.Email {
+			data.FieldErrors["email"] = h.i18n.Translate(data.Lang, "auth.register.error_email_invalid")
+		}
+		if refused := checkNewPassword(h.passwordPolicy, form.Password); refused != nil {
+			data.FieldErrors["password"] = passwordMessage(h.i18n, data.Lang, refused)
+		} else if form.Password != form.ConfirmPassword {
+			data.FieldErrors["confirm_password"] = h.i18n.Translate(data.Lang, "auth.register.error_mismatch")
+		}
+		if len(data.FieldErrors) > 0 {
+			data.Name, data.Email = form.Name, form.Email
+			tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "register", data)
 			return
 		}
 
 		// Check if user already exists
-		existingUser, err := h.db.GetUserByEmail(form.Email)
+		existingUser, err := h.db.WithContext(r.Context()).GetUserByEmail(form.Email)
 		if err != nil {
-			data.Error = "An error occurred"
-			h.tmpl.ExecuteTemplate(w, "register", data)
+			data.Error = h.i18n.Translate(data.Lang, "errors.generic")
+			tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "register", data)
 			return
 		}
 		if existingUser != nil {
-			data.Error = "Email already registered"
-			h.tmpl.ExecuteTemplate(w, "register", data)
+			data.FieldErrors["email"] = h.i18n.Translate(data.Lang, "auth.register.error_email_taken")
+			data.Name, data.Email = form.Name, form.Email
+			tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "register", data)
 			return
 		}
 
@@ -141,32 +367,25 @@ func (h *AuthHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 			Email: form.Email,
 			Name:  form.Name,
 		}
-		err = h.db.CreateUser(user, form.Password)
+		err = h.db.WithContext(r.Context()).CreateUser(user, form.Password)
 		if err != nil {
 			data.Error = "Failed to create user"
-			h.tmpl.ExecuteTemplate(w, "register", data)
+			tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "register", data)
 			return
 		}
 
-		// Set session
-		session, _ := h.store.Get(r, "session")
-		session.Values["user_id"] = user.ID
-		session.Values["user_email"] = user.Email
-		session.Values["user_name"] = user.Name
-		if err := session.Save(r, w); err != nil {
-			log.Printf("Error saving session: %v", err)
-			data.Error = "Failed to create session"
-			h.tmpl.ExecuteTemplate(w, "register", data)
-			return
+		// The account starts unverified until the emailed link is opened
+		if err := h.sendEmailVerification(r.Context(), user, data.Lang); err != nil {
+			slog.ErrorContext(r.Context(), "Error sending verification email", "user_id", user.ID, "error", err)
 		}
 
-		// Set user ID in context and redirect
-		r = r.WithContext(SetUserIDContext(r.Context(), user.ID))
+		// A new account has no second factor yet, so it is signed in at once
+		h.startSession(w, r, user, data.Lang)
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	h.tmpl.ExecuteTemplate(w, "register", data)
+	tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "register", data)
 }
 
 func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
@@ -176,19 +395,191 @@ func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
+// RequireAuth protects pages. Anonymous requests are redirected to the login
+// page, and accounts with an unverified email address are limited by the
+// configured unverified policy.
 func (h *AuthHandler) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return h.requireAuth(next, true, models.RoleUser)
+}
+
+// RequireAuthAllowUnverified protects the pages an unverified account must
+// always reach, such as the one that resends the verification email
+func (h *AuthHandler) RequireAuthAllowUnverified(next http.HandlerFunc) http.HandlerFunc {
+	return h.requireAuth(next, false, models.RoleUser)
+}
+
+// RequireRole protects pages only users with the role may reach. It works
+// like RequireAuth and answers 403 Forbidden to signed-in users without it.
+func (h *AuthHandler) RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return h.requireAuth(next, true, role)
+}
+
+func (h *AuthHandler) requireAuth(next http.HandlerFunc, enforcePolicy bool, role string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		session, _ := h.store.Get(r, "session")
-		userID, ok := session.Values["user_id"].(int64)
-		if !ok {
+		user, err := h.sessionUser(w, r)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking session", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		r = r.WithContext(SetUserIDContext(r.Context(), userID))
+		if enforcePolicy && !h.unverifiedAllowed(user, r) {
+			if h.unverifiedPolicy == models.UnverifiedBlock {
+				http.Redirect(w, r, "/verify-email", http.StatusSeeOther)
+				return
+			}
+			http.Error(w, h.i18n.Translate(i18n.GetLang(r.Context()), "auth.verify.required"), http.StatusForbidden)
+			return
+		}
+		if !user.HasRole(role) {
+			http.Error(w, h.i18n.Translate(i18n.GetLang(r.Context()), "auth.forbidden"), http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(SetUserIDContext(r.Context(), user.ID))
 		next(w, r)
 	}
 }
 
+// RequireAPIAuth protects API routes. It accepts a personal access token in
+// the Authorization header and falls back to the browser session cookie.
+// Either way the unverified policy applies as in RequireAuth. Unlike
+// RequireAuth it answers 401 instead of redirecting to the login page.
+func (h *AuthHandler) RequireAPIAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if header := r.Header.Get("Authorization"); header != "" {
+			plain, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				unauthorized(w, "Unsupported authorization scheme")
+				return
+			}
+
+			token, err := h.db.WithContext(r.Context()).GetAPITokenByHash(database.HashAPIToken(strings.TrimSpace(plain)))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error looking up API token", "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if token == nil || token.IsExpired(time.Now()) {
+				unauthorized(w, "Invalid or expired token")
+				return
+			}
+			owner, err := h.db.WithContext(r.Context()).GetUserByID(token.UserID)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error looking up API token owner", "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if owner == nil || owner.Disabled() {
+				unauthorized(w, "Account disabled")
+				return
+			}
+			if !h.unverifiedAllowed(owner, r) {
+				http.Error(w, "Email address not verified", http.StatusForbidden)
+				return
+			}
+			if !token.Allows(r.Method) {
+				http.Error(w, "Token scope does not allow this request", http.StatusForbidden)
+				return
+			}
+
+			if err := h.db.WithContext(r.Context()).TouchAPIToken(token.ID); err != nil {
+				slog.ErrorContext(r.Context(), "Error updating API token last use", "token_id", token.ID, "error", err)
+			}
+
+			r = r.WithContext(SetUserIDContext(r.Context(), token.UserID))
+			next(w, r)
+			return
+		}
+
+		user, err := h.sessionUser(w, r)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error checking session", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if user == nil {
+			unauthorized(w, "Authentication required")
+			return
+		}
+		if !h.unverifiedAllowed(user, r) {
+			http.Error(w, "Email address not verified", http.StatusForbidden)
+			return
+		}
+		r = r.WithContext(SetUserIDContext(r.Context(), user.ID))
+		next(w, r)
+	}
+}
+
+// sessionUser returns the user signed in to the browser session, or nil.
+// Sessions created before the user's session version was bumped, for example
+// by a password reset, and sessions of disabled accounts are signed out and
+// reported as anonymous.
+func (h *AuthHandler) sessionUser(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	session, _ := h.store.Get(r, "session")
+	userID, ok := session.Values["user_id"].(int64)
+	if !ok {
+		return nil, nil
+	}
+
+	user, err := h.db.WithContext(r.Context()).GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if version, _ := session.Values["session_version"].(int); user == nil || version != user.SessionVersion || user.Disabled() {
+		delete(session.Values, "user_id")
+		delete(session.Values, "user_email")
+		delete(session.Values, "user_name")
+		delete(session.Values, "user_role")
+		delete(session.Values, "session_version")
+		delete(session.Values, "email_verified")
+		if err := session.Save(r, w); err != nil {
+			slog.ErrorContext(r.Context(), "Error saving session", "error", err)
+		}
+		return nil, nil
+	}
+
+	// Keep the cached values used by templates in step with the database
+	verified, _ := session.Values["email_verified"].(bool)
+	role, _ := session.Values["user_role"].(string)
+	email, _ := session.Values["user_email"].(string)
+	name, _ := session.Values["user_name"].(string)
+	if verified != user.EmailVerified() || role != user.Role || email != user.Email || name != user.Name {
+		session.Values["email_verified"] = user.EmailVerified()
+		session.Values["user_role"] = user.Role
+		session.Values["user_email"] = user.Email
+		session.Values["user_name"] = user.Name
+		if err := session.Save(r, w); err != nil {
+			slog.ErrorContext(r.Context(), "Error saving session", "error", err)
+		}
+	}
+
+	return user, nil
+}
+
+// unverifiedAllowed reports whether the unverified policy lets the user make
+// the request. Verified users are always allowed.
+func (h *AuthHandler) unverifiedAllowed(user *models.User, r *http.Request) bool {
+	if user.EmailVerified() {
+		return true
+	}
+	switch h.unverifiedPolicy {
+	case models.UnverifiedBlock:
+		return false
+	case models.UnverifiedReadOnly:
+		return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+	}
+	return true
+}
+
+// unauthorized answers an API request that lacks valid credentials
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="expensemanager"`)
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
 // HandleLanguage handles language changes for both authenticated and unauthenticated users
 func (h *AuthHandler) HandleLanguage(w http.ResponseWriter, r *http.Request) {
 	lang := r.FormValue("lang")
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"expensemanager/internal/database"
+	"expensemanager/internal/i18n"
+	"expensemanager/internal/middleware"
+	"expensemanager/internal/models"
+
+	"github.com/gorilla/sessions"
+)
+
+// offlineConnector is a database that cannot be reached, for handlers whose
+// queries only matter to the response when they succeed
+type offlineConnector struct{}
+
+func (offlineConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("database offline")
+}
+
+func (offlineConnector) Driver() driver.Driver { return offlineDriver{} }
+
+type offlineDriver struct{}
+
+func (offlineDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("database offline")
+}
+
+// browser sends requests with the cookies earlier responses set
+type browser struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func (b *browser) do(method, path, csrfToken string) *httptest.ResponseRecorder {
+	b.t.Helper()
+	r := httptest.NewRequest(method, path, nil)
+	if csrfToken != "" {
+		r.Header.Set(middleware.CSRFHeader, csrfToken)
+	}
+	for _, cookie := range b.cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	b.handler.ServeHTTP(w, r)
+	for _, cookie := range w.Result().Cookies() {
+		b.cookies[cookie.Name] = cookie
+	}
+	return w
+}
+
+// token fetches the CSRF token a page of the session would render
+func (b *browser) token() string {
+	b.t.Helper()
+	body, _ := io.ReadAll(b.do(http.MethodGet, "/form", "").Body)
+	token := strings.TrimSpace(string(body))
+	if token == "" {
+		b.t.Fatal("no CSRF token")
+	}
+	return token
+}
+
+func TestSignInReplacesCSRFToken(t *testing.T) {
+	store := sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+	db := &database.DB{DB: sql.OpenDB(offlineConnector{})}
+	h := NewAuthHandler(db, nil, store)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /form", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, middleware.CSRFToken(r.Context()))
+	})
+	mux.HandleFunc("POST /signin", func(w http.ResponseWriter, r *http.Request) {
+		h.startSession(w, r, &models.User{ID: 1, Email: "ana@example.com"}, "en")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /expenses", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler := middleware.WithSessionStore(store)(middleware.CSRF(i18n.NewManager("en"))(mux))
+	b := &browser{t: t, handler: handler, cookies: make(map[string]*http.Cookie)}
+
+	// The attacker's session, and the token they know, before the victim
+	// signs in with it
+	planted := b.token()
+	if w := b.do(http.MethodPost, "/signin", planted); w.Code != http.StatusNoContent {
+		t.Fatalf("sign-in answered %d", w.Code)
+	}
+
+	if w := b.do(http.MethodPost, "/expenses", planted); w.Code != http.StatusForbidden {
+		t.Errorf("token from before sign-in answered %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	fresh := b.token()
+	if fresh == planted {
+		t.Fatal("sign-in kept the CSRF token")
+	}
+	if w := b.do(http.MethodPost, "/expenses", fresh); w.Code != http.StatusNoContent {
+		t.Errorf("new token answered %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
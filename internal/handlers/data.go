@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"expensemanager/internal/metrics"
+	"expensemanager/internal/models"
+	"expensemanager/internal/tracing"
+)
+
+type UploadResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// HandleData renders the tools for importing, exporting and clearing the
+// user's own expenses
+func (h *Handler) HandleData(w http.ResponseWriter, r *http.Request) {
+	// Get base template data
+	data := h.GetTemplateData(r)
+
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "data", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) HandleClearExpenses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	if err := h.db.WithContext(r.Context()).ClearExpenses(userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.publishExpensesChanged(r.Context(), userID, time.Time{})
+
+	expenses, err := h.db.WithContext(r.Context()).GetExpenses(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("HX-Trigger", "updateSummary")
+	tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "expenses-table.html", expenses)
+}
+
+func (h *Handler) HandleDownloadExpenses(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	expenses, err := h.db.WithContext(r.Context()).GetExpenses(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	expensesJSON := make([]models.ExpenseJSON, len(expenses))
+	for i, e := range expenses {
+		expensesJSON[i] = models.NewExpenseJSON(e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=expenses.json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(expensesJSON)
+}
+
+func (h *Handler) HandleUploadExpenses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	result := metrics.ResultFailure
+	defer func() {
+		metrics.ImportsRun.WithLabelValues(metrics.SourceUpload, result).Inc()
+	}()
+
+	// Parse multipart form
+	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10 MB max
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	// Get file from form
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Failed to get file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// Read file contents
+	fileBytes, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	// Parse JSON
+	var expenses []models.ExpenseJSON
+	if err := json.Unmarshal(fileBytes, &expenses); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	// Validate and add expenses
+	for _, e := range expenses {
+		// Parse date
+		date, err := time.Parse("2006-01-02", e.Date)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid date format for expense %d", e.ID), http.StatusBadRequest)
+			return
+		}
+
+		// Validate category
+		category, ok := models.CanonicalCategory(e.Category)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Invalid category for expense %d", e.ID), http.StatusBadRequest)
+			return
+		}
+
+		// Create expense
+		expense := &models.Expense{
+			UserID:      userID,
+			Amount:      e.Amount,
+			Description: e.Description,
+			Category:    category,
+			Date:        date,
+		}
+
+		if err := h.db.WithContext(r.Context()).AddExpense(expense); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to add expense %d", e.ID), http.StatusInternalServerError)
+			return
+		}
+		metrics.ExpensesCreated.WithLabelValues(metrics.SourceUpload).Inc()
+	}
+	result = metrics.ResultSuccess
+
+	if len(expenses) > 0 {
+		h.publishExpensesChanged(r.Context(), userID, time.Time{})
+	}
+
+	// Return success response
+	response := UploadResponse{
+		Success: true,
+		Message: fmt.Sprintf("Successfully uploaded %d expenses", len(expenses)),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
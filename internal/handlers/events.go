@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"expensemanager/internal/events"
+	"expensemanager/internal/models"
+)
+
+// eventsHeartbeat keeps idle event streams open through proxies
+const eventsHeartbeat = 25 * time.Second
+
+// publishExpensesChanged tells the user's other sessions to refresh. date is
+// the affected expense date, or the zero time if several months changed.
+func (h *Handler) publishExpensesChanged(ctx context.Context, userID int64, date time.Time) {
+	if h.events == nil {
+		return
+	}
+
+	event := events.Event{Type: events.ExpensesChanged, UserID: userID}
+	if !date.IsZero() {
+		event.Month = date.Format(models.MonthFormat)
+	}
+	if err := h.events.Publish(event); err != nil {
+		slog.ErrorContext(ctx, "Error publishing event", "type", event.Type, "user_id", userID, "error", err)
+	}
+}
+
+// HandleEvents streams the user's change events as Server-Sent Events
+func (h *Handler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		http.Error(w, "Live updates are disabled", http.StatusNotFound)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	// The stream stays open far longer than the server's read and write
+	// timeouts allow for ordinary requests
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		slog.WarnContext(r.Context(), "Event stream cannot lift the read deadline", "error", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(r.Context(), "Event stream cannot lift the write deadline", "error", err)
+	}
+	ch, unsubscribe := h.events.Subscribe(userID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// Tell the browser how long to wait before reconnecting
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		slog.WarnContext(r.Context(), "Event stream cannot be flushed", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -3,10 +3,16 @@ package handlers
 import (
 	"bytes"
 	"expensemanager/internal/database"
+	"expensemanager/internal/events"
 	"expensemanager/internal/i18n"
+	"expensemanager/internal/metrics"
+	"expensemanager/internal/middleware"
 	"expensemanager/internal/models"
+	"expensemanager/internal/passwords"
+	"expensemanager/internal/sso"
+	"expensemanager/internal/tracing"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
@@ -15,10 +21,15 @@ import (
 )
 
 type Handler struct {
-	db    *database.DB
-	tmpl  *template.Template
-	i18n  *i18n.Manager
-	store sessions.Store
+	db     *database.DB
+	tmpl   *template.Template
+	i18n   *i18n.Manager
+	store  sessions.Store
+	events events.Bus
+	// Rules new passwords must follow, shown as hints
+	passwordPolicy *passwords.Policy
+	// Identity providers users can link their account to
+	ssoProviders []*sso.Provider
 }
 
 func NewHandler(db *database.DB, tmpl *template.Template, store sessions.Store) *Handler {
@@ -34,6 +45,16 @@ func (h *Handler) UpdateI18n(manager *i18n.Manager) {
 	h.i18n = manager
 }
 
+// UpdateEventBus sets the bus used to notify other sessions of changes
+func (h *Handler) UpdateEventBus(bus events.Bus) {
+	h.events = bus
+}
+
+// UpdatePasswordPolicy sets the rules new passwords must follow
+func (h *Handler) UpdatePasswordPolicy(policy *passwords.Policy) {
+	h.passwordPolicy = policy
+}
+
 // TemplateData holds data to be passed to templates
 type TemplateData struct {
 	CurrentMonth       time.Time
@@ -51,14 +72,46 @@ type TemplateData struct {
 	MonthProgress      float64
 	DailyTrend         float64
 	// User information
-	UserID    int64
-	UserName  string
-	UserEmail string
-	Language  string
+	UserID        int64
+	UserName      string
+	UserEmail     string
+	Language      string
+	CSRFToken     string
+	EmailVerified bool
+	IsAdmin       bool
 	// Analytics fields
 	TotalSpent     float64
 	MonthlyTotals  []models.MonthlyTotal
 	MonthlyAverage float64
+	// Settings fields; FieldErrors holds errors shown under the form field
+	// they belong to, by field name
+	FieldErrors       map[string]string
+	PendingEmail      string
+	HasPassword       bool
+	PasswordMinLength int
+	APITokens         []models.APIToken
+	TokenScopes       []string
+	NewAPIToken       string
+	// Two-factor authentication fields
+	TOTPEnabled       bool
+	TOTPSetupSecret   string
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+	// Passkeys registered to the user
+	Passkeys []models.Credential
+	// Identity provider accounts linked to the user, and the providers' names by ID
+	Identities       []models.Identity
+	SSOProviderNames map[string]string
+	// Recent failed sign-ins to the account
+	LoginAttempts []models.LoginAttempt
+	// Sessions the user is signed in with
+	Sessions []models.Session
+	// Administration fields
+	AdminUsers []models.UserSummary
+	AuditLog   []models.AuditEntry
+	Storage    *models.StorageUsage
+	Search     string
+	Pagination Pagination
 }
 
 // GetTemplateData prepares common template data
@@ -68,6 +121,9 @@ func (h *Handler) GetTemplateData(r *http.Request) *TemplateData {
 		Lang:               lang,
 		AvailableLanguages: h.i18n.GetAvailableLanguages(),
 		Categories:         models.Categories(),
+		CSRFToken:          middleware.CSRFToken(r.Context()),
+		FieldErrors:        make(map[string]string),
+		PasswordMinLength:  passwordMinLength(h.passwordPolicy),
 	}
 
 	// Get user information from session
@@ -84,6 +140,9 @@ func (h *Handler) GetTemplateData(r *http.Request) *TemplateData {
 	if userEmail, ok := session.Values["user_email"].(string); ok {
 		data.UserEmail = userEmail
 	}
+	data.EmailVerified, _ = session.Values["email_verified"].(bool)
+	role, _ := session.Values["user_role"].(string)
+	data.IsAdmin = role == models.RoleAdmin
 
 	return data
 }
@@ -92,11 +151,10 @@ func (h *Handler) HandleIndex(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID, ok := GetUserIDFromContext(r.Context())
 	if !ok {
-		log.Printf("No user ID found in context")
+		slog.WarnContext(r.Context(), "No user ID found in context")
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return
 	}
-	log.Printf("Handling index for user ID: %d", userID)
 
 	// Get base template data
 	data := h.GetTemplateData(r)
@@ -114,14 +172,12 @@ func (h *Handler) HandleIndex(w http.ResponseWriter, r *http.Request) {
 
 	// Get expenses for current month
 	year, month := currentMonth.Year(), int(currentMonth.Month())
-	log.Printf("Fetching expenses for user %d, year %d, month %d", userID, year, month)
-	expenses, err := h.db.GetExpensesByMonth(userID, year, month)
+	expenses, err := h.db.WithContext(r.Context()).GetExpensesByMonth(userID, year, month)
 	if err != nil {
-		log.Printf("Error fetching expenses for user %d: %v", userID, err)
+		slog.ErrorContext(r.Context(), "Error fetching expenses", "year", year, "month", month, "error", err)
 		http.Error(w, "Failed to retrieve expenses", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("Found %d expenses for user %d", len(expenses), userID)
 	data.Expenses = expenses
 
 	// Calculate summary statistics
@@ -142,8 +198,8 @@ func (h *Handler) HandleIndex(w http.ResponseWriter, r *http.Request) {
 
 	// Buffer the template output before writing to ResponseWriter
 	var buf bytes.Buffer
-	if err := h.tmpl.ExecuteTemplate(&buf, "index.html", data); err != nil {
-		log.Printf("Template execution error for user %d: %v", userID, err)
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, &buf, "index.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing index template", "error", err)
 		http.Error(w, "Error rendering page", http.StatusInternalServerError)
 		return
 	}
@@ -173,7 +229,7 @@ func (h *Handler) HandleExpenses(w http.ResponseWriter, r *http.Request) {
 	}
 
 	year, month := monthDate.Year(), int(monthDate.Month())
-	expenses, err := h.db.GetExpensesByMonth(userID, year, month)
+	expenses, err := h.db.WithContext(r.Context()).GetExpensesByMonth(userID, year, month)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -181,7 +237,7 @@ func (h *Handler) HandleExpenses(w http.ResponseWriter, r *http.Request) {
 	data.Expenses = expenses
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := h.tmpl.ExecuteTemplate(w, "expenses-table", data); err != nil {
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "expenses-table", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -225,14 +281,16 @@ func (h *Handler) HandleAddExpense(w http.ResponseWriter, r *http.Request) {
 		Date:        date,
 	}
 
-	if err := h.db.AddExpense(expense); err != nil {
+	if err := h.db.WithContext(r.Context()).AddExpense(expense); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	metrics.ExpensesCreated.WithLabelValues(metrics.SourceForm).Inc()
+	h.publishExpensesChanged(r.Context(), userID, date)
 
 	// Get the month from the expense date
 	year, month := date.Year(), int(date.Month())
-	expenses, err := h.db.GetExpensesByMonth(userID, year, month)
+	expenses, err := h.db.WithContext(r.Context()).GetExpensesByMonth(userID, year, month)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -241,7 +299,7 @@ func (h *Handler) HandleAddExpense(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Header().Set("HX-Trigger", "updateSummary")
-	if err := h.tmpl.ExecuteTemplate(w, "expenses-table", data); err != nil {
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "expenses-table", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -266,10 +324,11 @@ func (h *Handler) HandleDeleteExpense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.db.DeleteExpense(userID, expenseID); err != nil {
+	if err := h.db.WithContext(r.Context()).DeleteExpense(userID, expenseID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.publishExpensesChanged(r.Context(), userID, time.Time{})
 
 	// Get the selected month from the query parameters
 	selectedMonth := r.URL.Query().Get("selected-month")
@@ -285,7 +344,7 @@ func (h *Handler) HandleDeleteExpense(w http.ResponseWriter, r *http.Request) {
 	}
 
 	year, month := monthDate.Year(), int(monthDate.Month())
-	expenses, err := h.db.GetExpensesByMonth(userID, year, month)
+	expenses, err := h.db.WithContext(r.Context()).GetExpensesByMonth(userID, year, month)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -294,7 +353,7 @@ func (h *Handler) HandleDeleteExpense(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.Header().Set("HX-Trigger", "updateSummary")
-	if err := h.tmpl.ExecuteTemplate(w, "expenses-table", data); err != nil {
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "expenses-table", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -328,7 +387,7 @@ func (h *Handler) HandleSummary(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get expenses for the selected month
-	expenses, err := h.db.GetExpensesByMonth(userID, monthDate.Year(), int(monthDate.Month()))
+	expenses, err := h.db.WithContext(r.Context()).GetExpensesByMonth(userID, monthDate.Year(), int(monthDate.Month()))
 	if err != nil {
 		http.Error(w, "Failed to get expenses", http.StatusInternalServerError)
 		return
@@ -373,7 +432,7 @@ func (h *Handler) HandleSummary(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := h.tmpl.ExecuteTemplate(w, "summary-cards", data); err != nil {
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "summary-cards", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
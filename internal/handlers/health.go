@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// readyTimeout bounds the checks of a readiness probe
+const readyTimeout = 2 * time.Second
+
+// HandleHealthz reports that the process is alive and serving requests. It
+// checks nothing else, so a failing database does not get the process restarted.
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// HandleReadyz reports whether the server can handle requests: the database
+// answers, its tables exist and the translations are loaded. It responds 503
+// with the failed checks otherwise, so load balancers route around the instance.
+func (h *Handler) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	// The probe is public, so failures are only detailed in the log
+	checks := map[string]string{"database": "ok", "schema": "ok", "translations": "ok"}
+	ready := true
+	if err := h.db.PingContext(ctx); err != nil {
+		slog.WarnContext(r.Context(), "Readiness check failed", "check", "database", "error", err)
+		checks["database"] = "failed"
+		checks["schema"] = "unknown"
+		ready = false
+	} else if err := h.db.CheckSchema(ctx); err != nil {
+		slog.WarnContext(r.Context(), "Readiness check failed", "check", "schema", "error", err)
+		checks["schema"] = "failed"
+		ready = false
+	}
+	if h.i18n == nil || !h.i18n.HasLanguage(h.i18n.GetDefaultLang()) {
+		slog.WarnContext(r.Context(), "Readiness check failed", "check", "translations", "error", "default language not loaded")
+		checks["translations"] = "failed"
+		ready = false
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Module    string `json:"module"`
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// ReadBuildInfo returns the module version and, for binaries built from a
+// git checkout, the commit they were built from
+func ReadBuildInfo() BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{Version: "unknown"}
+	}
+
+	build := BuildInfo{
+		Module:    info.Main.Path,
+		Version:   info.Main.Version,
+		GoVersion: info.GoVersion,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
+
+// HandleVersion reports which build of the server is running
+func HandleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, ReadBuildInfo())
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"expensemanager/internal/models"
+	"expensemanager/internal/openapi"
+)
+
+// APIVersion is the version of the JSON API contract
+const APIVersion = "1.0.0"
+
+// OpenAPISpec builds the OpenAPI document describing the /api endpoints.
+// Every route in APIRoutes must be described here; openapi_test.go fails and
+// the server refuses to start when the two drift apart.
+func OpenAPISpec() *openapi.Document {
+	doc := openapi.NewDocument("Expense Manager API", APIVersion)
+	doc.Info.Description = "JSON API for reading and managing expenses."
+
+	doc.Components.SecuritySchemes["sessionCookie"] = &openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "cookie",
+		Name:        "session",
+		Description: "Browser session created by /login",
+	}
+	doc.Components.SecuritySchemes["bearerToken"] = &openapi.SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "Personal access token created on the settings page. Read tokens may only issue GET requests.",
+	}
+	doc.Security = []openapi.SecurityRequirement{
+		{"bearerToken": {}},
+		{"sessionCookie": {}},
+	}
+
+	doc.RegisterSchema("Expense", models.Expense{})
+	expense := doc.RegisterSchema("ExpenseJSON", models.ExpenseJSON{})
+	doc.RegisterSchema("Analytics", models.Analytics{})
+	monthlyTotal := doc.RegisterSchema("MonthlyTotal", models.MonthlyTotal{})
+	categoryTotal := doc.RegisterSchema("CategoryTotal", models.CategoryTotal{})
+	yearlyReport := doc.RegisterSchema("YearlyReport", models.YearlyReport{})
+	importResult := doc.RegisterSchema("ImportResult", models.ImportResult{})
+	syncRequest := doc.RegisterSchema("SyncRequest", models.SyncRequest{})
+	syncResponse := doc.RegisterSchema("SyncResponse", models.SyncResponse{})
+
+	expenseID := openapi.Parameter{
+		Name:     "id",
+		In:       "path",
+		Required: true,
+		Schema:   openapi.Integer(),
+	}
+	expenseBody := &openapi.RequestBody{
+		Required: true,
+		Content: map[string]openapi.MediaType{
+			"application/json": {Schema: expense},
+		},
+	}
+	notFound := openapi.Response{Description: "Expense not found"}
+	badRequest := openapi.Response{Description: "Invalid input"}
+
+	doc.AddOperation(http.MethodGet, "/api/openapi.json", &openapi.Operation{
+		OperationID: "getOpenAPI",
+		Summary:     "This OpenAPI document",
+		Tags:        []string{"meta"},
+		Security:    openapi.Anonymous,
+		Responses: map[string]openapi.Response{
+			"200": jsonResponse("OpenAPI document", &openapi.Schema{Type: "object"}),
+		},
+	})
+
+	doc.AddOperation(http.MethodGet, "/api/monthly-totals", &openapi.Operation{
+		OperationID: "listMonthlyTotals",
+		Summary:     "Spending per month over the last twelve months",
+		Tags:        []string{"reports"},
+		Responses: map[string]openapi.Response{
+			"200": jsonResponse("Monthly totals, most recent first", openapi.ArrayOf(monthlyTotal)),
+		},
+	})
+
+	doc.AddOperation(http.MethodGet, "/api/category-totals", &openapi.Operation{
+		OperationID: "listCategoryTotals",
+		Summary:     "All-time spending per category",
+		Tags:        []string{"reports"},
+		Responses: map[string]openapi.Response{
+			"200": jsonResponse("Category totals", openapi.ArrayOf(categoryTotal)),
+		},
+	})
+
+	doc.AddOperation(http.MethodGet, "/api/expenses", &openapi.Operation{
+		OperationID: "listExpenses",
+		Summary:     "List expenses, optionally for a single month",
+		Tags:        []string{"expenses"},
+		Parameters: []openapi.Parameter{{
+			Name:        "month",
+			In:          "query",
+			Description: "Month in YYYY-MM format; all expenses when omitted",
+			Schema:      openapi.String(""),
+		}},
+		Responses: map[string]openapi.Response{
+			"200": jsonResponse("Expenses, most recent first", openapi.ArrayOf(expense)),
+			"400": badRequest,
+		},
+	})
+
+	doc.AddOperation(http.MethodPost, "/api/expenses", &openapi.Operation{
+		OperationID: "createExpense",
+		Summary:     "Add an expense",
+		Tags:        []string{"expenses"},
+		RequestBody: expenseBody,
+		Responses: map[string]openapi.Response{
+			"201": jsonResponse("The created expense", expense),
+			"400": badRequest,
+		},
+	})
+
+	doc.AddOperation(http.MethodGet, "/api/expenses/{id}", &openapi.Operation{
+		OperationID: "getExpense",
+		Summary:     "Get a single expense",
+		Tags:        []string{"expenses"},
+		Parameters:  []openapi.Parameter{expenseID},
+		Responses: map[string]openapi.Response{
+			"200": jsonResponse("The expense", expense),
+			"404": notFound,
+		},
+	})
+
+	doc.AddOperation(http.MethodPut, "/api/expenses/{id}", &openapi.Operation{
+		OperationID: "updateExpense",
+		Summary:     "Replace an expense",
+		Tags:        []string{"expenses"},
+		Parameters:  []openapi.Parameter{expenseID},
+		RequestBody: expenseBody,
+		Responses: map[string]openapi.Response{
+			"200": jsonResponse("The updated expense", expense),
+			"400": badRequest,
+			"404": notFound,
+		},
+	})
+
+	doc.AddOperation(http.MethodDelete, "/api/expenses/{id}", &openapi.Operation{
+		OperationID: "deleteExpense",
+		Summary:     "Delete an expense",
+		Tags:        []string{"expenses"},
+		Parameters:  []openapi.Parameter{expenseID},
+		Responses: map[string]openapi.Response{
+			"204": {Description: "Expense deleted"},
+			"404": notFound,
+		},
+	})
+
+	doc.AddOperation(http.MethodPost, "/api/expenses/import", &openapi.Operation{
+		OperationID: "importExpenses",
+		Summary:     "Add a batch of expenses; nothing is imported if any row is invalid",
+		Tags:        []string{"expenses"},
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content: map[string]openapi.MediaType{
+				"application/json": {Schema: openapi.ArrayOf(expense)},
+				"text/csv": {Schema: &openapi.Schema{
+					Type:        "string",
+					Description: "Header row id,date,category,description,amount; id is optional",
+				}},
+			},
+		},
+		Responses: map[string]openapi.Response{
+			"200": jsonResponse("Number of imported expenses", importResult),
+			"400": badRequest,
+		},
+	})
+
+	doc.AddOperation(http.MethodGet, "/api/expenses/export", &openapi.Operation{
+		OperationID: "exportExpenses",
+		Summary:     "Download all expenses",
+		Tags:        []string{"expenses"},
+		Parameters: []openapi.Parameter{{
+			Name:   "format",
+			In:     "query",
+			Schema: &openapi.Schema{Type: "string", Enum: []string{"json", "csv"}},
+		}},
+		Responses: map[string]openapi.Response{
+			"200": {
+				Description: "All expenses",
+				Content: map[string]openapi.MediaType{
+					"application/json": {Schema: openapi.ArrayOf(expense)},
+					"text/csv":         {Schema: openapi.String("")},
+				},
+			},
+			"400": badRequest,
+		},
+	})
+
+	doc.AddOperation(http.MethodGet, "/api/reports/yearly", &openapi.Operation{
+		OperationID: "getYearlyReport",
+		Summary:     "Monthly and category totals for one calendar year",
+		Tags:        []string{"reports"},
+		Parameters: []openapi.Parameter{{
+			Name:        "year",
+			In:          "query",
+			Description: "Calendar year; the current year when omitted",
+			Schema:      &openapi.Schema{Type: "integer", Format: "int32"},
+		}},
+		Responses: map[string]openapi.Response{
+			"200": jsonResponse("The yearly report", yearlyReport),
+			"400": badRequest,
+		},
+	})
+
+	doc.AddOperation(http.MethodPost, "/api/sync", &openapi.Operation{
+		OperationID: "syncExpenses",
+		Summary:     "Apply changes queued by an offline client",
+		Tags:        []string{"sync"},
+		RequestBody: &openapi.RequestBody{
+			Description: "Operations identified by client-generated UUIDs; replaying a batch is safe",
+			Required:    true,
+			Content: map[string]openapi.MediaType{
+				"application/json": {Schema: syncRequest},
+			},
+		},
+		Responses: map[string]openapi.Response{
+			"200": jsonResponse("One result per operation: applied, conflict or invalid", syncResponse),
+			"400": badRequest,
+			"413": {Description: "Too many operations in one batch"},
+		},
+	})
+
+	return doc
+}
+
+// APIRoute is an endpoint under /api and its handler
+type APIRoute struct {
+	Pattern string
+	Handler http.HandlerFunc
+}
+
+// APIRoutes lists every endpoint under /api, one pattern per method. The
+// server registers exactly these, so they can be checked against the document
+// served at /api/openapi.json; HandleAPIFallback answers everything else.
+func APIRoutes(doc *openapi.Document, h *Handler, auth *AuthHandler) []APIRoute {
+	return []APIRoute{
+		{"GET /api/openapi.json", HandleOpenAPI(doc)},
+		{"GET /api/monthly-totals", auth.RequireAPIAuth(h.HandleMonthlyTotals)},
+		{"GET /api/category-totals", auth.RequireAPIAuth(h.HandleCategoryTotals)},
+		{"GET /api/expenses", auth.RequireAPIAuth(h.HandleAPIExpenses)},
+		{"POST /api/expenses", auth.RequireAPIAuth(h.HandleAPIExpenses)},
+		{"GET /api/expenses/{id}", auth.RequireAPIAuth(h.HandleAPIExpense)},
+		{"PUT /api/expenses/{id}", auth.RequireAPIAuth(h.HandleAPIExpense)},
+		{"DELETE /api/expenses/{id}", auth.RequireAPIAuth(h.HandleAPIExpense)},
+		{"POST /api/expenses/import", auth.RequireAPIAuth(h.HandleAPIImport)},
+		{"GET /api/expenses/export", auth.RequireAPIAuth(h.HandleAPIExport)},
+		{"GET /api/reports/yearly", auth.RequireAPIAuth(h.HandleAPIYearlyReport)},
+		{"POST /api/sync", auth.RequireAPIAuth(h.HandleAPISync)},
+	}
+}
+
+// Patterns returns the patterns of the routes
+func Patterns(routes []APIRoute) []string {
+	patterns := make([]string, len(routes))
+	for i, route := range routes {
+		patterns[i] = route.Pattern
+	}
+	return patterns
+}
+
+// jsonResponse describes a successful application/json response
+func jsonResponse(description string, schema *openapi.Schema) openapi.Response {
+	return openapi.Response{
+		Description: description,
+		Content: map[string]openapi.MediaType{
+			"application/json": {Schema: schema},
+		},
+	}
+}
+
+// HandleOpenAPI serves the given OpenAPI document as JSON
+func HandleOpenAPI(doc *openapi.Document) http.HandlerFunc {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// HandleAPIFallback answers requests under /api that no route matched: 405
+// with an Allow header for a documented path, 404 for anything else. Without
+// it the site's catch-all would send API clients to the login page.
+func HandleAPIFallback(doc *openapi.Document) http.HandlerFunc {
+	paths := http.NewServeMux()
+	for path, item := range doc.Paths {
+		allow := strings.Join(item.Methods(), ", ")
+		paths.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", allow)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		})
+	}
+	paths.Handle("/", http.NotFoundHandler())
+	return paths.ServeHTTP
+}
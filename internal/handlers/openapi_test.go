@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// apiPatterns returns the patterns of the routes the server registers under
+// /api
+func apiPatterns() []string {
+	doc := OpenAPISpec()
+	return Patterns(APIRoutes(doc, NewHandler(nil, nil, nil), NewAuthHandler(nil, nil, nil)))
+}
+
+func TestAPIRoutesMatchOpenAPISpec(t *testing.T) {
+	if err := OpenAPISpec().VerifyRoutes(apiPatterns()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIRoutesAreUnderAPI(t *testing.T) {
+	for _, pattern := range apiPatterns() {
+		_, path, _ := strings.Cut(pattern, " ")
+		if !strings.HasPrefix(path, "/api/") {
+			t.Errorf("route %s is not under /api/", pattern)
+		}
+	}
+}
+
+func TestVerifyRoutesReportsDrift(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     string
+	}{
+		{
+			name:     "undocumented route",
+			patterns: append(apiPatterns(), "GET /api/budgets"),
+			want:     "registered but not documented: GET /api/budgets",
+		},
+		{
+			name:     "unregistered path",
+			patterns: apiPatterns()[1:],
+			want:     "documented but not registered: GET /api/openapi.json",
+		},
+		{
+			name:     "wrong method",
+			patterns: append(apiPatterns()[1:], "POST /api/openapi.json"),
+			want:     "registered but not documented: POST /api/openapi.json",
+		},
+		{
+			name:     "no method",
+			patterns: append(apiPatterns()[1:], "/api/openapi.json"),
+			want:     "documented but not registered: GET /api/openapi.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := OpenAPISpec().VerifyRoutes(tt.patterns)
+			if err == nil {
+				t.Fatal("expected the drift to be reported")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIFallback(t *testing.T) {
+	tests := []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{http.MethodPatch, "/api/expenses/1", http.StatusMethodNotAllowed, "GET, PUT, DELETE"},
+		{http.MethodDelete, "/api/expenses", http.StatusMethodNotAllowed, "GET, POST"},
+		{http.MethodGet, "/api/expenses/import", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodGet, "/api/budgets", http.StatusNotFound, ""},
+	}
+	handler := HandleAPIFallback(OpenAPISpec())
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"expensemanager/internal/i18n"
+	"expensemanager/internal/passkey"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Session keys holding the state of an unfinished passkey ceremony
+const (
+	passkeyRegistrationKey = "passkey_registration"
+	passkeyLoginKey        = "passkey_login"
+)
+
+// HandlePasskeyRegisterBegin starts registering a new passkey for the
+// signed-in user and answers with the options for navigator.credentials.create
+func (h *AuthHandler) HandlePasskeyRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	user, err := h.passkeyUser(r.Context(), userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	creation, session, err := h.webauthn.BeginRegistration(user, webauthn.WithExclusions(user.Exclusions()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.saveCeremony(w, r, passkeyRegistrationKey, session); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(creation)
+}
+
+// HandlePasskeyRegisterFinish verifies the authenticator's response and
+// stores the new passkey under the name given in the query string
+func (h *AuthHandler) HandlePasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+	lang := i18n.GetLang(r.Context())
+
+	session, ok := h.takeCeremony(w, r, passkeyRegistrationKey)
+	if !ok {
+		passkeyFailed(w, h.i18n.Translate(lang, "settings.passkeys.error_expired"))
+		return
+	}
+
+	user, err := h.passkeyUser(r.Context(), userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := h.webauthn.FinishRegistration(user, *session, r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Passkey registration failed", "error", describeWebAuthnError(err))
+		passkeyFailed(w, h.i18n.Translate(lang, "settings.passkeys.error_failed"))
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = h.i18n.Translate(lang, "settings.passkeys.default_name")
+	}
+	if err := h.db.WithContext(r.Context()).CreateCredential(passkey.NewCredential(userID, name, credential)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UploadResponse{
+		Success: true,
+		Message: h.i18n.Translate(lang, "settings.passkeys.added"),
+	})
+}
+
+// HandlePasskeyLoginBegin starts a sign-in with any passkey the browser holds
+// for this site, so no email address is needed
+func (h *AuthHandler) HandlePasskeyLoginBegin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	assertion, session, err := h.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.saveCeremony(w, r, passkeyLoginKey, session); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assertion)
+}
+
+// HandlePasskeyLoginFinish verifies the signed assertion and starts the
+// session. A passkey verifies the user on the device, so it satisfies
+// two-factor authentication on its own.
+func (h *AuthHandler) HandlePasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	lang := i18n.GetLang(r.Context())
+
+	session, ok := h.takeCeremony(w, r, passkeyLoginKey)
+	if !ok {
+		passkeyFailed(w, h.i18n.Translate(lang, "auth.passkey.error_expired"))
+		return
+	}
+
+	found, credential, err := h.webauthn.FinishPasskeyLogin(passkey.Lookup(func(userID int64) (*passkey.User, error) {
+		return h.passkeyUser(r.Context(), userID)
+	}), *session, r)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Passkey sign-in failed", "error", describeWebAuthnError(err))
+		passkeyFailed(w, h.i18n.Translate(lang, "auth.passkey.error_failed"))
+		return
+	}
+	user := found.(*passkey.User).Account
+	if user.Disabled() {
+		passkeyFailed(w, h.i18n.Translate(lang, "auth.login.disabled"))
+		return
+	}
+	if user.MustResetPassword {
+		passkeyFailed(w, h.i18n.Translate(lang, "auth.login.reset_required"))
+		return
+	}
+
+	// A counter that went backwards means the key may have been copied
+	if err := passkey.CheckCounter(credential); err != nil {
+		slog.WarnContext(r.Context(), "Passkey sign-in refused", "user_id", user.ID, "error", err)
+		passkeyFailed(w, h.i18n.Translate(lang, "auth.passkey.error_failed"))
+		return
+	}
+	if err := h.db.WithContext(r.Context()).UpdateCredentialUse(credential.ID, credential.Authenticator.SignCount, credential.Flags.BackupState); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.startSession(w, r, user, r.URL.Query().Get("language"))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UploadResponse{Success: true})
+}
+
+// passkeyUser loads an account with its passkeys, returning nil if there is
+// no such account
+func (h *AuthHandler) passkeyUser(ctx context.Context, userID int64) (*passkey.User, error) {
+	account, err := h.db.WithContext(ctx).GetUserByID(userID)
+	if err != nil || account == nil {
+		return nil, err
+	}
+	credentials, err := h.db.WithContext(ctx).GetCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &passkey.User{Account: account, Credentials: credentials}, nil
+}
+
+// saveCeremony keeps the challenge of a ceremony in the session until the
+// browser answers it
+func (h *AuthHandler) saveCeremony(w http.ResponseWriter, r *http.Request, key string, data *webauthn.SessionData) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	session, _ := h.store.Get(r, "session")
+	session.Values[key] = encoded
+	return session.Save(r, w)
+}
+
+// takeCeremony removes a pending ceremony from the session so each challenge
+// can be answered only once
+func (h *AuthHandler) takeCeremony(w http.ResponseWriter, r *http.Request, key string) (*webauthn.SessionData, bool) {
+	session, _ := h.store.Get(r, "session")
+	encoded, ok := session.Values[key].([]byte)
+	if !ok {
+		return nil, false
+	}
+	delete(session.Values, key)
+	if err := session.Save(r, w); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving session", "error", err)
+	}
+
+	data := &webauthn.SessionData{}
+	if err := json.Unmarshal(encoded, data); err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// passkeyFailed answers a ceremony request that could not be completed
+func passkeyFailed(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(UploadResponse{Message: message})
+}
+
+// describeWebAuthnError includes the library's detail, which its Error method
+// leaves out, in log messages
+func describeWebAuthnError(err error) string {
+	var e *protocol.Error
+	if errors.As(err, &e) {
+		return fmt.Sprintf("%s: %s %s", e.Type, e.Details, e.DevInfo)
+	}
+	return err.Error()
+}
+
+// HandleDeletePasskey removes one of the user's passkeys
+func (h *Handler) HandleDeletePasskey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	// An account without a password keeps at least one way to sign in
+	last, err := h.lastSignInMethod(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if last {
+		redirectAccount(w, r, "last_sign_in")
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).DeleteCredential(userID, id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Passkey not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"errors"
+
+	"expensemanager/internal/i18n"
+	"expensemanager/internal/passwords"
+)
+
+// checkNewPassword applies the password policy to a password a user chose.
+// Without a policy every password is accepted.
+func checkNewPassword(policy *passwords.Policy, password string) *passwords.Error {
+	if policy == nil {
+		return nil
+	}
+	var refused *passwords.Error
+	if errors.As(policy.Check(password), &refused) {
+		return refused
+	}
+	return nil
+}
+
+// passwordMessage explains in the user's language why a password was refused
+func passwordMessage(manager *i18n.Manager, lang string, err *passwords.Error) string {
+	key := "password.error." + err.Reason
+	switch err.Reason {
+	case passwords.TooShort:
+		return manager.Plural(lang, key, err.MinLength, nil)
+	case passwords.TooLong:
+		return manager.Plural(lang, key, passwords.MaxBytes, nil)
+	}
+	return manager.Translate(lang, key)
+}
+
+// passwordMinLength is the shortest password the policy accepts, shown as a
+// hint next to new password fields
+func passwordMinLength(policy *passwords.Policy) int {
+	if policy == nil {
+		return 0
+	}
+	return policy.MinLength
+}
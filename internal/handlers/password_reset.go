@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"expensemanager/internal/database"
+	"expensemanager/internal/models"
+	"expensemanager/internal/tracing"
+)
+
+// Throttling of password reset emails to one account
+const (
+	resetResendInterval   = time.Minute
+	resetResendDailyLimit = 5
+)
+
+// HandleForgotPassword asks for an email address and sends a reset link to it.
+// The response is the same whether or not an account exists for the address:
+// the account is looked up and the link mailed in the background, so neither
+// the answer nor its timing tells. Each address may ask a few times before it
+// must wait, and each account gets a limited number of links a day.
+func (h *AuthHandler) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := h.GetTemplateData(r)
+
+	if r.Method == http.MethodPost {
+		ip := h.ClientIP(r)
+		if wait := h.resetBackoff.Wait(ip); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			data.Error = h.i18n.Translate(data.Lang, "auth.forgot.too_many")
+			h.renderAuth(w, r, "forgot-password", data)
+			return
+		}
+		h.resetBackoff.Failure(ip)
+
+		email := strings.TrimSpace(r.FormValue("email"))
+		ctx := context.WithoutCancel(r.Context())
+		h.background.Add(1)
+		go func() {
+			defer h.background.Done()
+			h.requestPasswordReset(ctx, email, data.Lang)
+		}()
+
+		data.Success = h.i18n.Translate(data.Lang, "auth.forgot.sent")
+	}
+
+	h.renderAuth(w, r, "forgot-password", data)
+}
+
+// requestPasswordReset mails a reset link to the account with the email, if
+// there is one and it has not had too many links lately
+func (h *AuthHandler) requestPasswordReset(ctx context.Context, email, lang string) {
+	user, err := h.db.WithContext(ctx).GetUserByEmail(email)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error looking up user for password reset", "error", err)
+		return
+	}
+	if user == nil {
+		return
+	}
+
+	now := time.Now()
+	count, latest, err := h.db.WithContext(ctx).CountRecentUserTokens(user.ID, models.TokenPurposePasswordReset, now.Add(-24*time.Hour))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error counting password resets", "user_id", user.ID, "error", err)
+		return
+	}
+	if (latest != nil && now.Sub(*latest) < resetResendInterval) || count >= resetResendDailyLimit {
+		slog.InfoContext(ctx, "Password reset throttled", "user_id", user.ID)
+		return
+	}
+
+	if err := h.sendPasswordReset(ctx, user, lang); err != nil {
+		slog.ErrorContext(ctx, "Error sending password reset", "user_id", user.ID, "error", err)
+	}
+}
+
+// sendPasswordReset issues a reset token for the user and mails the link
+func (h *AuthHandler) sendPasswordReset(ctx context.Context, user *models.User, lang string) error {
+	return h.sendTokenEmail(ctx, user, user.Email, lang, models.TokenPurposePasswordReset, models.PasswordResetTTL, "password_reset", "/reset-password")
+}
+
+// sendTokenEmail issues a single-use token for the user and mails a link to
+// path carrying it to the address to. Delivery happens in the background so
+// a slow mail server does not hold up the response.
+func (h *AuthHandler) sendTokenEmail(ctx context.Context, user *models.User, to, lang, purpose string, ttl time.Duration, template, path string) error {
+	token, err := database.GenerateUserToken()
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.WithContext(ctx).CreateUserToken(user.ID, purpose, database.HashUserToken(token), time.Now().Add(ttl)); err != nil {
+		return err
+	}
+
+	msg, err := h.mailTemplates.Render(to, template, map[string]string{
+		"Lang": lang,
+		"Name": user.Name,
+		"Link": h.baseURL + path + "?token=" + url.QueryEscape(token),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The request may finish before the mail is sent, but the log records
+	// should still carry its trace and user
+	mailCtx := context.WithoutCancel(ctx)
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		if err := h.mailer.Send(mailCtx, msg); err != nil {
+			slog.ErrorContext(mailCtx, "Error mailing link", "purpose", purpose, "user_id", user.ID, "error", err)
+		}
+	}()
+	return nil
+}
+
+// HandleResetPassword sets a new password using the token from a reset link
+func (h *AuthHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	data := h.GetTemplateData(r)
+
+	if r.Method != http.MethodPost {
+		token := r.URL.Query().Get("token")
+		valid, err := h.db.WithContext(r.Context()).GetValidUserToken(models.TokenPurposePasswordReset, database.HashUserToken(token))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if valid == nil {
+			data.Error = h.i18n.Translate(data.Lang, "auth.reset.invalid")
+		} else {
+			data.Token = token
+		}
+		h.renderAuth(w, r, "reset-password", data)
+		return
+	}
+
+	data.Token = r.FormValue("token")
+	password := r.FormValue("password")
+
+	if password == "" {
+		data.Error = h.i18n.Translate(data.Lang, "auth.reset.error_empty")
+		h.renderAuth(w, r, "reset-password", data)
+		return
+	}
+	if refused := checkNewPassword(h.passwordPolicy, password); refused != nil {
+		data.FieldErrors["password"] = passwordMessage(h.i18n, data.Lang, refused)
+		h.renderAuth(w, r, "reset-password", data)
+		return
+	}
+	if password != r.FormValue("confirm_password") {
+		data.FieldErrors["confirm_password"] = h.i18n.Translate(data.Lang, "auth.reset.error_mismatch")
+		h.renderAuth(w, r, "reset-password", data)
+		return
+	}
+
+	if _, err := h.db.WithContext(r.Context()).ResetPassword(database.HashUserToken(data.Token), password); err != nil {
+		if err == sql.ErrNoRows {
+			data.Token = ""
+			data.Error = h.i18n.Translate(data.Lang, "auth.reset.invalid")
+			h.renderAuth(w, r, "reset-password", data)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/login?reset=done", http.StatusSeeOther)
+}
+
+// renderAuth executes one of the signed-out page templates
+func (h *AuthHandler) renderAuth(w http.ResponseWriter, r *http.Request, name string, data *AuthTemplateData) {
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, name, data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing template", "template", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
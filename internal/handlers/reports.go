@@ -3,6 +3,9 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+
+	"expensemanager/internal/models"
+	"expensemanager/internal/tracing"
 )
 
 func (h *Handler) HandleReports(w http.ResponseWriter, r *http.Request) {
@@ -13,7 +16,7 @@ func (h *Handler) HandleReports(w http.ResponseWriter, r *http.Request) {
 	data := h.GetTemplateData(r)
 
 	// Get analytics data
-	analytics, err := h.db.GetAnalytics(userID)
+	analytics, err := h.db.WithContext(r.Context()).GetAnalytics(userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -25,7 +28,7 @@ func (h *Handler) HandleReports(w http.ResponseWriter, r *http.Request) {
 	data.MonthlyTotals = analytics.MonthlyTotals
 	data.MonthlyAverage = analytics.MonthlyAverage
 
-	if err := h.tmpl.ExecuteTemplate(w, "reports", data); err != nil {
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "reports", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -35,7 +38,7 @@ func (h *Handler) HandleMonthlyTotals(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID, _ := GetUserIDFromContext(r.Context())
 
-	analytics, err := h.db.GetAnalytics(userID)
+	analytics, err := h.db.WithContext(r.Context()).GetAnalytics(userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -49,17 +52,17 @@ func (h *Handler) HandleCategoryTotals(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context
 	userID, _ := GetUserIDFromContext(r.Context())
 
-	analytics, err := h.db.GetAnalytics(userID)
+	analytics, err := h.db.WithContext(r.Context()).GetAnalytics(userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	categories := make([]map[string]interface{}, 0)
+	categories := make([]models.CategoryTotal, 0, len(analytics.CategoryTotals))
 	for category, total := range analytics.CategoryTotals {
-		categories = append(categories, map[string]interface{}{
-			"category": category,
-			"total":    total,
+		categories = append(categories, models.CategoryTotal{
+			Category: category,
+			Total:    total,
 		})
 	}
 
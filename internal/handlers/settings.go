@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"expensemanager/internal/database"
+	"expensemanager/internal/models"
+	"expensemanager/internal/tracing"
+)
+
+// loginAttemptsShown is how many recent failed sign-ins the settings page lists
+const loginAttemptsShown = 20
+
+// HandleSettings renders the account settings page
+func (h *Handler) HandleSettings(w http.ResponseWriter, r *http.Request) {
+	data := h.GetTemplateData(r)
+	h.accountResult(r, data)
+	h.renderSettings(w, r, data)
+}
+
+// renderSettings loads the user's pending email change, API tokens, passkeys,
+// linked identities, two-factor state, failed sign-ins and sessions and
+// executes the settings template
+func (h *Handler) renderSettings(w http.ResponseWriter, r *http.Request, data *TemplateData) {
+	userID, _ := GetUserIDFromContext(r.Context())
+	db := h.db.WithContext(r.Context())
+
+	tokens, err := db.GetAPITokens(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.APITokens = tokens
+	data.TokenScopes = models.Scopes()
+
+	data.Passkeys, err = db.GetCredentials(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data.Identities, err = db.GetIdentities(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data.SSOProviderNames = make(map[string]string)
+	for _, provider := range h.ssoProviders {
+		data.SSOProviderNames[provider.ID] = provider.Name
+	}
+
+	data.LoginAttempts, err = db.GetLoginAttempts(userID, loginAttemptsShown)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, _ := h.store.Get(r, "session")
+	data.Sessions, err = db.GetSessions(userID, database.HashSessionToken(session.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := db.GetUserByID(userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	data.PendingEmail = user.PendingEmail
+	data.HasPassword = user.HasPassword()
+	data.TOTPEnabled = user.TOTPEnabled()
+	if data.TOTPEnabled {
+		data.RecoveryCodesLeft, err = db.CountRecoveryCodes(userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		// An enrollment started with HandleTOTPSetup waits for confirmation
+		data.TOTPSetupSecret, _ = session.Values["totp_setup_secret"].(string)
+	}
+
+	if err := tracing.ExecuteTemplate(r.Context(), h.tmpl, w, "settings", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error executing settings template", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// HandleCreateAPIToken mints a new personal access token and shows it once
+func (h *Handler) HandleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	data := h.GetTemplateData(r)
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		data.Error = h.i18n.Translate(data.Lang, "settings.tokens.error_name")
+		h.renderSettings(w, r, data)
+		return
+	}
+
+	scope := r.FormValue("scope")
+	if scope != models.ScopeRead && scope != models.ScopeWrite {
+		data.Error = h.i18n.Translate(data.Lang, "settings.tokens.error_scope")
+		h.renderSettings(w, r, data)
+		return
+	}
+
+	token := &models.APIToken{
+		UserID: userID,
+		Name:   name,
+		Scope:  scope,
+	}
+
+	// An empty or zero expiry means the token never expires
+	if days, err := strconv.Atoi(r.FormValue("expires_in_days")); err == nil && days > 0 {
+		expiresAt := time.Now().AddDate(0, 0, days)
+		token.ExpiresAt = &expiresAt
+	}
+
+	plain, err := database.GenerateAPIToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).CreateAPIToken(token, database.HashAPIToken(plain)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data.NewAPIToken = plain
+	h.renderSettings(w, r, data)
+}
+
+// HandleRevokeAPIToken deletes one of the user's personal access tokens
+func (h *Handler) HandleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	tokenID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).DeleteAPIToken(userID, tokenID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// HandleRevokeSession signs out one of the user's sessions, for example on a
+// lost device
+func (h *Handler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	sessionID, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).DeleteSession(userID, sessionID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// HandleRevokeAllSessions signs the user out everywhere, including this browser
+func (h *Handler) HandleRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	if err := h.db.WithContext(r.Context()).DeleteUserSessions(userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, _ := h.store.Get(r, "session")
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		slog.ErrorContext(r.Context(), "Error clearing session", "error", err)
+	}
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
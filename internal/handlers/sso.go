@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"expensemanager/internal/models"
+	"expensemanager/internal/sso"
+)
+
+// ssoLoginKey is the session value that remembers a sign-in in progress at
+// an identity provider
+const ssoLoginKey = "sso_login"
+
+// UpdateSSOProviders sets the OpenID Connect identity providers users can sign
+// in with
+func (h *AuthHandler) UpdateSSOProviders(providers []*sso.Provider) {
+	h.ssoProviders = providers
+}
+
+// UpdateSSOProviders sets the identity providers, whose names are shown with
+// the accounts linked to them
+func (h *Handler) UpdateSSOProviders(providers []*sso.Provider) {
+	h.ssoProviders = providers
+}
+
+// ssoProvider returns the provider with the ID, or nil if there is none
+func (h *AuthHandler) ssoProvider(id string) *sso.Provider {
+	for _, provider := range h.ssoProviders {
+		if provider.ID == id {
+			return provider
+		}
+	}
+	return nil
+}
+
+// HandleSSOLogin sends the user to sign in at the identity provider named in
+// the path
+func (h *AuthHandler) HandleSSOLogin(w http.ResponseWriter, r *http.Request) {
+	provider := h.ssoProvider(r.PathValue("provider"))
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	authURL, login, err := provider.Begin(r.URL.Query().Get("language"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error starting single sign-on", "provider", provider.ID, "error", err)
+		h.ssoFailed(w, r, "auth.sso.error_unavailable")
+		return
+	}
+	encoded, err := sso.EncodeLogin(login)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, _ := h.store.Get(r, "session")
+	session.Values[ssoLoginKey] = encoded
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// HandleSSOCallback finishes a sign-in when the identity provider sends the
+// user back. The verified identity signs in to the account linked to it. Like
+// a password, it is followed by the second step when the account has
+// two-factor authentication.
+func (h *AuthHandler) HandleSSOCallback(w http.ResponseWriter, r *http.Request) {
+	provider := h.ssoProvider(r.PathValue("provider"))
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Each sign-in can be finished only once
+	var login *sso.Login
+	session, _ := h.store.Get(r, "session")
+	if encoded, ok := session.Values[ssoLoginKey].([]byte); ok {
+		login, _ = sso.DecodeLogin(encoded)
+		delete(session.Values, ssoLoginKey)
+		if err := session.Save(r, w); err != nil {
+			slog.ErrorContext(r.Context(), "Error saving session", "error", err)
+		}
+	}
+
+	// The provider reports a refused or cancelled sign-in in the query
+	query := r.URL.Query()
+	if code := query.Get("error"); code != "" {
+		slog.WarnContext(r.Context(), "Single sign-on failed at the provider", "provider", provider.ID, "error", code, "description", query.Get("error_description"))
+		h.ssoFailed(w, r, "auth.sso.error_failed")
+		return
+	}
+
+	identity, err := provider.Finish(r.Context(), login, query.Get("state"), query.Get("code"))
+	if err == sso.ErrLoginExpired {
+		h.ssoFailed(w, r, "auth.sso.error_expired")
+		return
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "Single sign-on failed", "provider", provider.ID, "error", err)
+		h.ssoFailed(w, r, "auth.sso.error_failed")
+		return
+	}
+
+	user, reason, err := h.ssoUser(r.Context(), provider, identity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if reason != "" {
+		h.ssoFailed(w, r, reason)
+		return
+	}
+	if user.Disabled() {
+		h.ssoFailed(w, r, "auth.login.disabled")
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).RecordIdentityLogin(user.ID, provider.ID, identity.Subject, identity.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.completeLogin(w, r, user, login.Language)
+}
+
+// ssoUser finds the account an identity signs in to: the one already linked
+// to it, else the account with the same email address, else a new account if
+// the provider may create them. Linking by email requires both the provider
+// and the account to have verified the address, so nobody can take over an
+// account by registering its address at a provider, or the other way around.
+// When the identity may not sign in it returns the translation key of the
+// reason.
+func (h *AuthHandler) ssoUser(ctx context.Context, provider *sso.Provider, identity *sso.Identity) (*models.User, string, error) {
+	user, err := h.db.WithContext(ctx).GetUserByIdentity(provider.ID, identity.Subject)
+	if err != nil || user != nil {
+		return user, "", err
+	}
+
+	if identity.Email == "" {
+		return nil, "auth.sso.error_no_email", nil
+	}
+	if !identity.EmailVerified {
+		return nil, "auth.sso.error_unverified", nil
+	}
+
+	user, err = h.db.WithContext(ctx).GetUserByEmail(identity.Email)
+	if err != nil {
+		return nil, "", err
+	}
+	if user != nil {
+		if !user.EmailVerified() {
+			return nil, "auth.sso.error_link_unverified", nil
+		}
+		slog.InfoContext(ctx, "Linked identity to existing user", "provider", provider.ID, "subject", identity.Subject, "user_id", user.ID)
+		return user, "", nil
+	}
+
+	if !provider.AutoProvision {
+		return nil, "auth.sso.error_no_account", nil
+	}
+	user = &models.User{Email: identity.Email, Name: identity.Name}
+	if err := h.db.WithContext(ctx).CreateIdentityUser(user, provider.ID, identity.Subject); err != nil {
+		return nil, "", err
+	}
+	slog.InfoContext(ctx, "Created user for identity", "provider", provider.ID, "subject", identity.Subject, "user_id", user.ID)
+
+	// The address is verified, so it may be one of the bootstrap administrators
+	h.promoteAdmins(ctx)
+	user, err = h.db.WithContext(ctx).GetUserByID(user.ID)
+	return user, "", err
+}
+
+// ssoFailed shows the sign-in page with the reason a sign-in with an
+// identity provider did not work
+func (h *AuthHandler) ssoFailed(w http.ResponseWriter, r *http.Request, key string) {
+	data := h.GetTemplateData(r)
+	data.Error = h.i18n.Translate(data.Lang, key)
+	h.renderAuth(w, r, "login", data)
+}
+
+// HandleDeleteIdentity unlinks an identity provider account from the user
+func (h *Handler) HandleDeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid identity ID", http.StatusBadRequest)
+		return
+	}
+
+	// An account without a password keeps at least one way to sign in
+	last, err := h.lastSignInMethod(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if last {
+		redirectAccount(w, r, "last_sign_in")
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).DeleteIdentity(userID, id); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Identity not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
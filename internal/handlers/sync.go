@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"expensemanager/internal/models"
+)
+
+// HandleAPISync applies a batch of operations queued by an offline client.
+// Operations are applied in order and independently; each gets a result.
+func (h *Handler) HandleAPISync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	var req models.SyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Operations) > models.MaxSyncOperations {
+		http.Error(w, fmt.Sprintf("At most %d operations per request", models.MaxSyncOperations), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	resp := models.SyncResponse{Results: make([]models.SyncResult, 0, len(req.Operations))}
+	changed := false
+
+	for _, op := range req.Operations {
+		result, err := h.applySyncOperation(r.Context(), userID, op)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error syncing expense", "client_id", op.ClientID, "error", err)
+			http.Error(w, "Failed to apply sync operation", http.StatusInternalServerError)
+			return
+		}
+		if result.Status == models.SyncApplied {
+			changed = true
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	if changed {
+		h.publishExpensesChanged(r.Context(), userID, time.Time{})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// applySyncOperation validates and applies a single sync operation
+func (h *Handler) applySyncOperation(ctx context.Context, userID int64, op models.SyncOperation) (models.SyncResult, error) {
+	result := models.SyncResult{ClientID: op.ClientID}
+
+	invalid := func(message string) (models.SyncResult, error) {
+		result.Status = models.SyncInvalid
+		result.Error = message
+		return result, nil
+	}
+
+	if !models.IsValidUUID(op.ClientID) {
+		return invalid("client_id must be a UUID")
+	}
+	if op.Action != models.SyncUpsert && op.Action != models.SyncDelete {
+		return invalid(fmt.Sprintf("unknown action %q", op.Action))
+	}
+
+	var base *time.Time
+	if op.BaseUpdatedAt != "" {
+		t, err := time.Parse(time.RFC3339Nano, op.BaseUpdatedAt)
+		if err != nil {
+			return invalid("base_updated_at must be an RFC 3339 timestamp")
+		}
+		base = &t
+	}
+
+	var expense models.Expense
+	if op.Action == models.SyncUpsert {
+		var err error
+		if expense, err = op.Expense.ToExpense(userID); err != nil {
+			return invalid(err.Error())
+		}
+	}
+
+	status, current, err := h.db.WithContext(ctx).SyncExpense(userID, op.ClientID, op.Action, &expense, base)
+	if err != nil {
+		return result, err
+	}
+
+	result.Status = status
+	if current != nil {
+		e := models.NewExpenseJSON(*current)
+		result.Expense = &e
+	}
+	return result, nil
+}
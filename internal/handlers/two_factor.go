@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"expensemanager/internal/database"
+	"expensemanager/internal/metrics"
+	"expensemanager/internal/models"
+	"expensemanager/internal/totp"
+
+	"rsc.io/qr"
+)
+
+// totpIssuer names the account in authenticator apps
+const totpIssuer = "Expense Manager"
+
+// Limits on the second login step
+const (
+	pendingLoginTTL         = 5 * time.Minute
+	maxSecondFactorAttempts = 5
+)
+
+// verifySecondFactor accepts either a current TOTP code or an unused
+// recovery code. Each code is accepted at most once.
+func verifySecondFactor(db *database.DB, user *models.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, nil
+	}
+
+	if len(code) == totp.Digits && strings.Trim(code, "0123456789") == "" {
+		counter, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return db.UseTOTPCounter(user.ID, counter)
+	}
+
+	return db.UseRecoveryCode(user.ID, database.HashRecoveryCode(code))
+}
+
+// HandleLoginSecondFactor is the second login step for accounts with
+// two-factor authentication. The password step leaves the user ID in the
+// session as pending; the real session only starts once a code is accepted.
+func (h *AuthHandler) HandleLoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	data := h.GetTemplateData(r)
+
+	session, _ := h.store.Get(r, "session")
+	userID, ok := session.Values["pending_user_id"].(int64)
+	startedAt, _ := session.Values["pending_at"].(int64)
+	if !ok || time.Since(time.Unix(startedAt, 0)) > pendingLoginTTL {
+		h.clearPendingLogin(w, r)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		h.renderAuth(w, r, "login-2fa", data)
+		return
+	}
+
+	user, err := h.db.WithContext(r.Context()).GetUserByID(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil || !user.TOTPEnabled() || user.Locked(time.Now()) || user.Disabled() || user.MustResetPassword {
+		h.clearPendingLogin(w, r)
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	ok, err = verifySecondFactor(h.db.WithContext(r.Context()), user, r.FormValue("code"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		// Wrong codes count towards the same lockout and slow-downs, per
+		// address and per account, as wrong passwords
+		ip := h.ClientIP(r)
+		h.ipBackoff.Failure(ip)
+		h.accountBackoff.Failure(strings.ToLower(user.Email))
+		metrics.LoginFailures.WithLabelValues(models.LoginFailedSecondFactor).Inc()
+		if err := h.db.WithContext(r.Context()).RecordLoginFailure(user.ID, &models.LoginAttempt{IP: ip, UserAgent: r.UserAgent()}, models.LoginFailedSecondFactor); err != nil {
+			slog.ErrorContext(r.Context(), "Error recording failed login", "user_id", user.ID, "error", err)
+		}
+
+		attempts, _ := session.Values["pending_attempts"].(int)
+		attempts++
+		if attempts >= maxSecondFactorAttempts {
+			h.clearPendingLogin(w, r)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		session.Values["pending_attempts"] = attempts
+		session.Save(r, w)
+
+		data.Error = h.i18n.Translate(data.Lang, "auth.2fa.invalid")
+		h.renderAuth(w, r, "login-2fa", data)
+		return
+	}
+
+	h.accountBackoff.Success(strings.ToLower(user.Email))
+	language, _ := session.Values["pending_language"].(string)
+	h.startSession(w, r, user, language)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// clearPendingLogin forgets a half-finished two-step login
+func (h *AuthHandler) clearPendingLogin(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.store.Get(r, "session")
+	delete(session.Values, "pending_user_id")
+	delete(session.Values, "pending_language")
+	delete(session.Values, "pending_at")
+	delete(session.Values, "pending_attempts")
+	session.Save(r, w)
+}
+
+// HandleTOTPSetup starts enrollment. The new secret lives in the session
+// until the user proves their authenticator app produces matching codes.
+func (h *Handler) HandleTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session, _ := h.store.Get(r, "session")
+	session.Values["totp_setup_secret"] = secret
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// HandleTOTPQRCode renders the pending enrollment secret as a QR code PNG
+func (h *Handler) HandleTOTPQRCode(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.store.Get(r, "session")
+	secret, _ := session.Values["totp_setup_secret"].(string)
+	email, _ := session.Values["user_email"].(string)
+	if secret == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	code, err := qr.Encode(totp.URI(totpIssuer, email, secret), qr.M)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(code.PNG())
+}
+
+// HandleTOTPEnable finishes enrollment once the user enters a valid code, and
+// shows the recovery codes once
+func (h *Handler) HandleTOTPEnable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	session, _ := h.store.Get(r, "session")
+	secret, _ := session.Values["totp_setup_secret"].(string)
+	if secret == "" {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return
+	}
+
+	data := h.GetTemplateData(r)
+
+	counter, ok := totp.Validate(secret, r.FormValue("code"), time.Now())
+	if !ok {
+		data.Error = h.i18n.Translate(data.Lang, "settings.2fa.error_code")
+		h.renderSettings(w, r, data)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.db.WithContext(r.Context()).EnableTOTP(userID, secret, counter, hashes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	delete(session.Values, "totp_setup_secret")
+	session.Save(r, w)
+
+	data.RecoveryCodes = codes
+	h.renderSettings(w, r, data)
+}
+
+// HandleTOTPDisable turns two-factor authentication off after checking a
+// current code or recovery code
+func (h *Handler) HandleTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := h.checkSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.WithContext(r.Context()).DisableTOTP(user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// HandleRegenerateRecoveryCodes replaces the recovery codes after checking a
+// current code, and shows the new ones once
+func (h *Handler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := h.checkSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.db.WithContext(r.Context()).ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := h.GetTemplateData(r)
+	data.RecoveryCodes = codes
+	h.renderSettings(w, r, data)
+}
+
+// checkSecondFactor loads the signed-in user and verifies the code in the
+// request. When it returns false the response has already been written.
+func (h *Handler) checkSecondFactor(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	user, err := h.db.WithContext(r.Context()).GetUserByID(userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !user.TOTPEnabled() {
+		http.Redirect(w, r, "/settings", http.StatusSeeOther)
+		return nil, false
+	}
+
+	ok, err := verifySecondFactor(h.db.WithContext(r.Context()), user, r.FormValue("code"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if !ok {
+		data := h.GetTemplateData(r)
+		data.Error = h.i18n.Translate(data.Lang, "settings.2fa.error_code")
+		h.renderSettings(w, r, data)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// newRecoveryCodes returns a set of recovery codes and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := database.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = database.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"expensemanager/internal/database"
+	"expensemanager/internal/models"
+)
+
+// Throttling of verification email resends
+const (
+	verifyResendInterval   = time.Minute
+	verifyResendDailyLimit = 5
+)
+
+// sendEmailVerification mails the user a link that confirms their address
+func (h *AuthHandler) sendEmailVerification(ctx context.Context, user *models.User, lang string) error {
+	return h.sendTokenEmail(ctx, user, user.Email, lang, models.TokenPurposeEmailVerify, models.EmailVerifyTTL, "verify_email", "/verify-email")
+}
+
+// HandleVerifyEmail confirms an address with the token from a verification
+// link. Without a token it shows the signed-in user how to verify.
+func (h *AuthHandler) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	data := h.GetTemplateData(r)
+
+	// The link may be opened on a device that is not signed in
+	if token := r.URL.Query().Get("token"); token != "" {
+		if _, err := h.db.WithContext(r.Context()).VerifyEmail(database.HashUserToken(token)); err != nil {
+			if err != sql.ErrNoRows {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			data.Error = h.i18n.Translate(data.Lang, "auth.verify.invalid")
+		} else {
+			data.Success = h.i18n.Translate(data.Lang, "auth.verify.success")
+			h.promoteAdmins(r.Context())
+		}
+	}
+
+	user, err := h.sessionUser(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil && data.Error == "" && data.Success == "" {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	data.User = user
+
+	h.renderAuth(w, r, "verify-email", data)
+}
+
+// promoteAdmins gives the administrator role to listed addresses that are now
+// verified
+func (h *AuthHandler) promoteAdmins(ctx context.Context) {
+	promoted, err := h.db.WithContext(ctx).PromoteAdmins(h.adminEmails)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error promoting administrators", "error", err)
+		return
+	}
+	if promoted > 0 {
+		slog.InfoContext(ctx, "Promoted accounts listed in ADMIN_EMAILS to administrator", "count", promoted)
+	}
+}
+
+// HandleResendVerification sends a fresh verification link. Resends are
+// limited to one a minute and a handful a day per account.
+func (h *AuthHandler) HandleResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user ID from context
+	userID, _ := GetUserIDFromContext(r.Context())
+
+	user, err := h.db.WithContext(r.Context()).GetUserByID(userID)
+	if err != nil || user == nil {
+		http.Error(w, "Failed to load user", http.StatusInternalServerError)
+		return
+	}
+	if user.EmailVerified() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	data := h.GetTemplateData(r)
+	data.User = user
+
+	now := time.Now()
+	count, latest, err := h.db.WithContext(r.Context()).CountRecentUserTokens(user.ID, models.TokenPurposeEmailVerify, now.Add(-24*time.Hour))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case latest != nil && now.Sub(*latest) < verifyResendInterval:
+		data.Error = h.i18n.Translate(data.Lang, "auth.verify.too_soon")
+	case count >= verifyResendDailyLimit:
+		data.Error = h.i18n.Translate(data.Lang, "auth.verify.too_many")
+	default:
+		if err := h.sendEmailVerification(r.Context(), user, data.Lang); err != nil {
+			slog.ErrorContext(r.Context(), "Error sending verification email", "error", err)
+			data.Error = h.i18n.Translate(data.Lang, "auth.verify.send_failed")
+		} else {
+			data.Success = h.i18n.Translate(data.Lang, "auth.verify.sent")
+		}
+	}
+
+	h.renderAuth(w, r, "verify-email", data)
+}
@@ -1,73 +1,143 @@
 package i18n
 
 import (
+	"embed"
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
 	"strings"
 )
 
+//go:embed locales/*.json
+var locales embed.FS
+
+// Locales returns the translation files built into the binary, one
+// <language>.json file per language
+func Locales() fs.FS {
+	sub, err := fs.Sub(locales, "locales")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
 // Manager handles translations for different languages
 type Manager struct {
 	defaultLang  string
-	translations map[string]map[string]string
+	translations map[string]map[string]Message
 }
 
 // NewManager creates a new i18n manager with the specified default language
 func NewManager(defaultLang string) *Manager {
 	return &Manager{
 		defaultLang:  defaultLang,
-		translations: make(map[string]map[string]string),
+		translations: make(map[string]map[string]Message),
 	}
 }
 
-// LoadTranslations loads all translation files from the specified directory
-func (m *Manager) LoadTranslations(dir string) error {
-	entries, err := os.ReadDir(dir)
+// LoadTranslations loads all translation files at the root of fsys, such as
+// Locales() or os.DirFS of a directory. Strings are added to those loaded
+// before, so a directory loaded after the built-in files can override single
+// strings or add a language.
+func (m *Manager) LoadTranslations(fsys fs.FS) error {
+	files, err := ReadLocales(fsys)
 	if err != nil {
-		return fmt.Errorf("failed to read translations directory: %w", err)
+		return err
+	}
+
+	for lang, translations := range files {
+		if m.translations[lang] == nil {
+			m.translations[lang] = make(map[string]Message, len(translations))
+		}
+		for key, translation := range translations {
+			m.translations[lang][key] = translation
+		}
 	}
 
+	return nil
+}
+
+// ReadLocales reads the translation files at the root of fsys, keyed by
+// language
+func ReadLocales(fsys fs.FS) (map[string]map[string]Message, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read translations directory: %w", err)
+	}
+
+	files := make(map[string]map[string]Message)
 	for _, entry := range entries {
 		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
 			lang := strings.TrimSuffix(entry.Name(), ".json")
 
-			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			data, err := fs.ReadFile(fsys, entry.Name())
 			if err != nil {
-				return fmt.Errorf("failed to read translation file %s: %w", entry.Name(), err)
+				return nil, fmt.Errorf("failed to read translation file %s: %w", entry.Name(), err)
 			}
 
-			var translations map[string]string
+			var translations map[string]Message
 			if err := json.Unmarshal(data, &translations); err != nil {
-				return fmt.Errorf("failed to parse translation file %s: %w", entry.Name(), err)
+				return nil, fmt.Errorf("failed to parse translation file %s: %w", entry.Name(), err)
 			}
 
-			m.translations[lang] = translations
+			files[lang] = translations
 		}
 	}
 
-	return nil
+	return files, nil
 }
 
 // Translate returns the translation for the given key in the specified language
 func (m *Manager) Translate(lang, key string) string {
+	if message, _, ok := m.lookup(lang, key); ok {
+		return message.Text
+	}
+
+	// Return key if no translation found
+	return key
+}
+
+// Format returns the translation for the given key with the parameters
+// substituted for their {name} placeholders
+func (m *Manager) Format(lang, key string, params Params) string {
+	return interpolate(m.Translate(lang, key), params)
+}
+
+// Plural returns the form of the translation for the given key that suits
+// count, with count and the parameters substituted for their placeholders.
+// The form follows the plural rules of the language the translation is in,
+// which is the default language when lang lacks the key.
+func (m *Manager) Plural(lang, key string, count any, params Params) string {
+	message, found, ok := m.lookup(lang, key)
+	if !ok {
+		return key
+	}
+
+	values := Params{"count": count}
+	for name, value := range params {
+		values[name] = value
+	}
+	return interpolate(message.form(found, count), values)
+}
+
+// lookup finds the message for key in lang, falling back to the default
+// language, and returns the language it was found in
+func (m *Manager) lookup(lang, key string) (Message, string, bool) {
 	// Try requested language
 	if translations, ok := m.translations[lang]; ok {
-		if translation, ok := translations[key]; ok {
-			return translation
+		if message, ok := translations[key]; ok {
+			return message, lang, true
 		}
 	}
 
 	// Fallback to default language
 	if translations, ok := m.translations[m.defaultLang]; ok {
-		if translation, ok := translations[key]; ok {
-			return translation
+		if message, ok := translations[key]; ok {
+			return message, m.defaultLang, true
 		}
 	}
 
-	// Return key if no translation found
-	return key
+	return Message{}, "", false
 }
 
 // GetDefaultLang returns the default language
@@ -75,6 +145,12 @@ func (m *Manager) GetDefaultLang() string {
 	return m.defaultLang
 }
 
+// HasLanguage reports whether translations for lang are loaded
+func (m *Manager) HasLanguage(lang string) bool {
+	_, ok := m.translations[lang]
+	return ok
+}
+
 // GetAvailableLanguages returns a list of available languages
 func (m *Manager) GetAvailableLanguages() []string {
 	langs := make([]string, 0, len(m.translations))
@@ -0,0 +1,95 @@
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Message is the translation of one key. In a translation file it is either a
+// string or, for text that depends on a number, an object of forms:
+//
+//	"summary.expense_count": {
+//	    "=0": "No expenses this month",
+//	    "one": "{count} expense this month",
+//	    "other": "{count} expenses this month"
+//	}
+//
+// Forms are keyed by the CLDR plural categories the language uses (zero, one,
+// two, few, many and other) or by an exact count such as "=0", which wins over
+// the category. Both kinds of text may name parameters in braces.
+type Message struct {
+	Text string
+	// Forms by plural category or exact count; nil for a plain string
+	Forms map[string]string
+}
+
+// UnmarshalJSON reads a message from a string or an object of forms
+func (m *Message) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.Text); err == nil {
+		m.Forms = nil
+		return nil
+	}
+	if err := json.Unmarshal(data, &m.Forms); err != nil {
+		return errors.New("expected a string or an object of plural forms")
+	}
+	other, ok := m.Forms[PluralOther]
+	if !ok {
+		return errors.New("plural forms must include other")
+	}
+	// Without a count, the message reads as its general form
+	m.Text = other
+	return nil
+}
+
+// form returns the text of the message for count in lang
+func (m Message) form(lang string, count any) string {
+	if m.Forms == nil {
+		return m.Text
+	}
+	if text, ok := m.Forms["="+fmt.Sprint(count)]; ok {
+		return text
+	}
+	if text, ok := m.Forms[PluralCategory(lang, count)]; ok {
+		return text
+	}
+	return m.Text
+}
+
+// Params are the named values substituted for {name} in a message
+type Params map[string]any
+
+// Pairs turns alternating names and values, as template functions receive
+// them, into Params
+func Pairs(args ...any) (Params, error) {
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("parameters must come in name and value pairs, got %d arguments", len(args))
+	}
+	params := make(Params, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		name, ok := args[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("parameter name %v is not a string", args[i])
+		}
+		params[name] = args[i+1]
+	}
+	return params, nil
+}
+
+// placeholder matches {name} in a message. Other braces are left alone.
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// interpolate replaces the placeholders in text with the named parameters.
+// Placeholders without a parameter are kept, so a missing value shows.
+func interpolate(text string, params Params) string {
+	if len(params) == 0 {
+		return text
+	}
+	return placeholder.ReplaceAllStringFunc(text, func(match string) string {
+		if value, ok := params[match[1:len(match)-1]]; ok {
+			return fmt.Sprint(value)
+		}
+		return match
+	})
+}
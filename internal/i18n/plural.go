@@ -0,0 +1,175 @@
+package i18n
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CLDR plural categories
+const (
+	PluralZero  = "zero"
+	PluralOne   = "one"
+	PluralTwo   = "two"
+	PluralFew   = "few"
+	PluralMany  = "many"
+	PluralOther = "other"
+)
+
+// operands are the CLDR plural operands of a number, as written: 1 and 1.0
+// differ in English ("1 expense", "1.0 expenses")
+type operands struct {
+	n float64 // absolute value
+	i int64   // integer digits
+	v int     // number of visible fraction digits
+}
+
+func newOperands(count any) operands {
+	var s string
+	switch c := count.(type) {
+	case float64:
+		s = strconv.FormatFloat(c, 'f', -1, 64)
+	case float32:
+		s = strconv.FormatFloat(float64(c), 'f', -1, 32)
+	default:
+		// Integers, and strings such as "1.50" that keep their fraction digits
+		s = fmt.Sprint(c)
+	}
+	s = strings.TrimPrefix(s, "-")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	var o operands
+	o.n, _ = strconv.ParseFloat(s, 64)
+	o.i, _ = strconv.ParseInt(whole, 10, 64)
+	o.v = len(fraction)
+	return o
+}
+
+// pluralRule gives the plural category of a number in a language
+type pluralRule struct {
+	categories []string
+	category   func(o operands) string
+}
+
+// oneIfInteger1 is the rule of English and German: "1 expense", "0 expenses"
+var oneIfInteger1 = pluralRule{
+	categories: []string{PluralOne, PluralOther},
+	category: func(o operands) string {
+		if o.i == 1 && o.v == 0 {
+			return PluralOne
+		}
+		return PluralOther
+	},
+}
+
+// withMillions extends the rule of a Romance language whose whole millions
+// take a form of their own, as in "1 milhão de despesas"
+func withMillions(one func(o operands) bool) pluralRule {
+	return pluralRule{
+		categories: []string{PluralOne, PluralMany, PluralOther},
+		category: func(o operands) string {
+			switch {
+			case one(o):
+				return PluralOne
+			case o.v == 0 && o.i != 0 && o.i%1000000 == 0:
+				return PluralMany
+			}
+			return PluralOther
+		},
+	}
+}
+
+// slavic is the rule of Russian and Ukrainian
+var slavic = pluralRule{
+	categories: []string{PluralOne, PluralFew, PluralMany, PluralOther},
+	category: func(o operands) string {
+		if o.v != 0 {
+			return PluralOther
+		}
+		switch mod10, mod100 := o.i%10, o.i%100; {
+		case mod10 == 1 && mod100 != 11:
+			return PluralOne
+		case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+			return PluralFew
+		}
+		return PluralMany
+	},
+}
+
+// pluralRules holds the CLDR rules of the languages the application is likely
+// to be translated to. Compact notation such as "1,2 mil" is not supported.
+var pluralRules = map[string]pluralRule{
+	"en": oneIfInteger1,
+	"de": oneIfInteger1,
+	"nl": oneIfInteger1,
+	"sv": oneIfInteger1,
+	"es": withMillions(func(o operands) bool { return o.n == 1 }),
+	"fr": withMillions(func(o operands) bool { return o.i == 0 || o.i == 1 }),
+	"it": withMillions(func(o operands) bool { return o.i == 1 && o.v == 0 }),
+	// Portuguese as spoken in Brazil, which CLDR uses for pt, and in Portugal
+	"pt":    withMillions(func(o operands) bool { return o.i == 0 || o.i == 1 }),
+	"pt-PT": withMillions(func(o operands) bool { return o.i == 1 && o.v == 0 }),
+	"ru":    slavic,
+	"uk":    slavic,
+	"pl": {
+		categories: []string{PluralOne, PluralFew, PluralMany, PluralOther},
+		category: func(o operands) string {
+			if o.v != 0 {
+				return PluralOther
+			}
+			switch mod10, mod100 := o.i%10, o.i%100; {
+			case o.i == 1:
+				return PluralOne
+			case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+				return PluralFew
+			}
+			return PluralMany
+		},
+	},
+	"cs": {
+		categories: []string{PluralOne, PluralFew, PluralMany, PluralOther},
+		category: func(o operands) string {
+			switch {
+			case o.v != 0:
+				return PluralMany
+			case o.i == 1:
+				return PluralOne
+			case o.i >= 2 && o.i <= 4:
+				return PluralFew
+			}
+			return PluralOther
+		},
+	},
+}
+
+// noPlural is the rule of languages without plural forms, such as Japanese,
+// and of languages missing from pluralRules
+var noPlural = pluralRule{
+	categories: []string{PluralOther},
+	category:   func(operands) string { return PluralOther },
+}
+
+// rule returns the plural rule of lang, which may carry a region (pt-PT)
+func rule(lang string) pluralRule {
+	lang = strings.ReplaceAll(lang, "_", "-")
+	if r, ok := pluralRules[lang]; ok {
+		return r
+	}
+	base, _, _ := strings.Cut(lang, "-")
+	if r, ok := pluralRules[base]; ok {
+		return r
+	}
+	return noPlural
+}
+
+// PluralCategory returns the CLDR plural category of count in lang. count is
+// an integer, a float or a number written as a string.
+func PluralCategory(lang string, count any) string {
+	return rule(lang).category(newOperands(count))
+}
+
+// PluralCategories returns the plural categories lang uses, which plural
+// messages in that language should all have
+func PluralCategories(lang string) []string {
+	return rule(lang).categories
+}
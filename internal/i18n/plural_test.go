@@ -0,0 +1,107 @@
+package i18n
+
+import (
+	"slices"
+	"testing"
+)
+
+// boundaryCounts are the counts where the rules of the supported languages
+// change category: zero, one, two, the teens and the hundreds
+var boundaryCounts = []int{0, 1, 2, 5, 11, 21, 22, 25, 101, 111}
+
+func TestPluralCategoryAtBoundaries(t *testing.T) {
+	const (
+		one   = PluralOne
+		few   = PluralFew
+		many  = PluralMany
+		other = PluralOther
+	)
+	tests := []struct {
+		langs []string
+		want  []string // one per boundary count
+	}{
+		{[]string{"en", "de", "nl", "sv"}, []string{other, one, other, other, other, other, other, other, other, other}},
+		{[]string{"es"}, []string{other, one, other, other, other, other, other, other, other, other}},
+		{[]string{"fr"}, []string{one, one, other, other, other, other, other, other, other, other}},
+		{[]string{"it"}, []string{other, one, other, other, other, other, other, other, other, other}},
+		{[]string{"pt"}, []string{one, one, other, other, other, other, other, other, other, other}},
+		{[]string{"pt-PT"}, []string{other, one, other, other, other, other, other, other, other, other}},
+		{[]string{"ru", "uk"}, []string{many, one, few, many, many, one, few, many, one, many}},
+		{[]string{"pl"}, []string{many, one, few, many, many, many, few, many, many, many}},
+		{[]string{"cs"}, []string{other, one, few, other, other, other, other, other, other, other}},
+		{[]string{"ja"}, []string{other, other, other, other, other, other, other, other, other, other}},
+	}
+	for _, tt := range tests {
+		for _, lang := range tt.langs {
+			t.Run(lang, func(t *testing.T) {
+				for i, count := range boundaryCounts {
+					got := PluralCategory(lang, count)
+					if got != tt.want[i] {
+						t.Errorf("PluralCategory(%q, %d) = %q, want %q", lang, count, got, tt.want[i])
+					}
+					if !slices.Contains(PluralCategories(lang), got) {
+						t.Errorf("PluralCategory(%q, %d) = %q, which is not one of %v", lang, count, got, PluralCategories(lang))
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPluralCategoryOfWrittenNumbers(t *testing.T) {
+	tests := []struct {
+		lang  string
+		count any
+		want  string
+	}{
+		// Visible fraction digits change the category
+		{"en", "1.0", PluralOther},
+		{"en", 1.5, PluralOther},
+		{"en", -1, PluralOne},
+		{"es", 1.0, PluralOne},
+		{"fr", 1.5, PluralOne},
+		{"fr", 2.5, PluralOther},
+		{"pt", "0.50", PluralOne},
+		{"pt-PT", "1.0", PluralOther},
+		{"ru", 1.5, PluralOther},
+		{"pl", "2.0", PluralOther},
+		{"cs", 1.5, PluralMany},
+
+		// Whole millions in the Romance languages
+		{"es", 1000000, PluralMany},
+		{"fr", 2000000, PluralMany},
+		{"it", 1000001, PluralOther},
+		{"pt", "1000000", PluralMany},
+		{"pt-PT", "1000000.5", PluralOther},
+
+		// Regions fall back to their language, and unknown languages have
+		// no plural forms
+		{"en-GB", 1, PluralOne},
+		{"pt_BR", 0, PluralOne},
+		{"pt_PT", 0, PluralOther},
+		{"xx", 1, PluralOther},
+	}
+	for _, tt := range tests {
+		if got := PluralCategory(tt.lang, tt.count); got != tt.want {
+			t.Errorf("PluralCategory(%q, %v) = %q, want %q", tt.lang, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestPluralCategories(t *testing.T) {
+	tests := []struct {
+		lang string
+		want []string
+	}{
+		{"en", []string{PluralOne, PluralOther}},
+		{"pt", []string{PluralOne, PluralMany, PluralOther}},
+		{"ru", []string{PluralOne, PluralFew, PluralMany, PluralOther}},
+		{"cs", []string{PluralOne, PluralFew, PluralMany, PluralOther}},
+		{"ja", []string{PluralOther}},
+	}
+	for _, tt := range tests {
+		if got := PluralCategories(tt.lang); !slices.Equal(got, tt.want) {
+			t.Errorf("PluralCategories(%q) = %v, want %v", tt.lang, got, tt.want)
+		}
+	}
+}
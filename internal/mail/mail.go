@@ -0,0 +1,72 @@
+// Package mail sends transactional email such as password reset links.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"mime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Templates renders localized messages. Every email is a pair of templates
+// named "<name>.subject" and "<name>.body".
+type Templates struct {
+	tmpl *template.Template
+}
+
+// ParseTemplates parses the email templates matching pattern in fsys
+func ParseTemplates(fsys fs.FS, pattern string, funcs template.FuncMap) (*Templates, error) {
+	tmpl, err := template.New("").Funcs(funcs).ParseFS(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Templates{tmpl: tmpl}, nil
+}
+
+// Render builds the message called name for the given recipient
+func (t *Templates) Render(to, name string, data interface{}) (Message, error) {
+	var subject, body bytes.Buffer
+	if err := t.tmpl.ExecuteTemplate(&subject, name+".subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := t.tmpl.ExecuteTemplate(&body, name+".body", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimSpace(body.String()) + "\n",
+	}, nil
+}
+
+// format encodes the message as an RFC 5322 document
+func (m Message) format(from string, now time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
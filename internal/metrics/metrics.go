@@ -0,0 +1,97 @@
+// Package metrics defines the Prometheus metrics the server exposes on
+// /metrics, next to the Go runtime and process metrics of the client library.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "expensemanager"
+
+// Sources of new expenses, for ExpensesCreated and ImportsRun
+const (
+	SourceForm      = "form"       // the add expense form
+	SourceUpload    = "upload"     // a CSV uploaded on the data page
+	SourceAPI       = "api"        // POST /api/expenses
+	SourceAPIImport = "api_import" // POST /api/expenses/import
+)
+
+// Results of an import, for ImportsRun
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+var (
+	// HTTPRequestDuration times requests by method, matched route pattern
+	// and response status
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// DBQueryDuration times database queries and transactions by the
+	// database.DB method that issued them
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Time taken by database queries.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method"})
+
+	// ExpensesCreated counts new expenses by where they came from
+	ExpensesCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expenses_created_total",
+		Help:      "Expenses created.",
+	}, []string{"source"})
+
+	// ImportsRun counts bulk imports by source and result
+	ImportsRun = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "imports_total",
+		Help:      "Expense imports run.",
+	}, []string{"source", "result"})
+
+	// LoginFailures counts failed sign-in attempts by reason
+	LoginFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Failed sign-in attempts.",
+	}, []string{"reason"})
+)
+
+// Register adds a collector, such as the connection pool statistics of the
+// database, to the metrics served by Handler
+func Register(c prometheus.Collector) error {
+	return prometheus.Register(c)
+}
+
+// Handler serves the metrics in the Prometheus text format. A non-empty
+// token must be sent as a bearer token, keeping the metrics private on
+// servers that are reachable from the internet.
+func Handler(token string) http.Handler {
+	metrics := promhttp.Handler()
+	if token == "" {
+		return metrics
+	}
+
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
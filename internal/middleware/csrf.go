@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"expensemanager/internal/i18n"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// CSRFHeader is the request header HTMX and scripts send the token in
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField is the form field plain HTML forms send the token in
+	CSRFField = "csrf_token"
+
+	csrfSessionKey = "csrf_token"
+)
+
+type csrfTokenKey struct{}
+
+// csrfState is the token of the request's session. A session without one
+// gets it the first time a page asks for it, so requests that render no form,
+// such as those for static files, never store a session.
+type csrfState struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	session *sessions.Session
+	token   string
+}
+
+// get returns the session's token, creating and saving it if there is none
+func (s *csrfState) get() string {
+	if s.token != "" {
+		return s.token
+	}
+
+	token, err := generateCSRFToken()
+	if err != nil {
+		slog.ErrorContext(s.r.Context(), "Error generating CSRF token", "error", err)
+		return ""
+	}
+	s.session.Values[csrfSessionKey] = token
+	if err := s.session.Save(s.r, s.w); err != nil {
+		slog.ErrorContext(s.r.Context(), "Error saving session", "error", err)
+	}
+	s.token = token
+	return token
+}
+
+// CSRF protects state-changing requests against cross-site request forgery.
+// Each session has a random token that is exposed to templates through
+// CSRFToken; POST, PUT, PATCH and DELETE requests must echo it back in the
+// X-CSRF-Token header or the csrf_token form field. A session without a token
+// has never been shown a form, so its unsafe requests are refused. API
+// requests that authenticate with a bearer token carry no ambient credentials
+// and are exempt.
+func CSRF(manager *i18n.Manager) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Token-authenticated API calls need no session, and skipping it
+			// keeps scripts from creating a server-side session per request
+			if isBearerAPIRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			store, ok := GetSessionStore(r.Context())
+			if !ok {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			// A session that fails to decode is replaced by a fresh one
+			session, _ := store.Get(r, "session")
+
+			token, _ := session.Values[csrfSessionKey].(string)
+
+			if !isSafeMethod(r.Method) {
+				sent := r.Header.Get(CSRFHeader)
+				if sent == "" {
+					sent = r.FormValue(CSRFField)
+				}
+				if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					lang := i18n.GetLang(r.Context())
+					http.Error(w, manager.Translate(lang, "errors.csrf"), http.StatusForbidden)
+					return
+				}
+			}
+
+			state := &csrfState{w: w, r: r, session: session, token: token}
+			ctx := context.WithValue(r.Context(), csrfTokenKey{}, state)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// CSRFToken returns the CSRF token of the current session. The first call
+// for a session without one stores the session, so it must come before the
+// response is written.
+func CSRFToken(ctx context.Context) string {
+	state, ok := ctx.Value(csrfTokenKey{}).(*csrfState)
+	if !ok {
+		return ""
+	}
+	return state.get()
+}
+
+// ResetCSRFToken removes the session's token, so the next page that needs one
+// gets a new one. Signing in calls it: a token an attacker learned from a
+// session planted before sign-in must not work afterwards.
+func ResetCSRFToken(session *sessions.Session) {
+	delete(session.Values, csrfSessionKey)
+}
+
+// generateCSRFToken returns 32 random bytes encoded as URL-safe base64
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// isSafeMethod reports whether the method is not expected to change state
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// isBearerAPIRequest reports whether the request authenticates to the API
+// with a token instead of the session cookie
+func isBearerAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") &&
+		strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"expensemanager/internal/metrics"
+)
+
+type routeKey struct{}
+
+// Metrics times each request by method, route pattern and status. The route
+// is only known once the router has matched the request, so the router must
+// be wrapped with Route for it to be recorded.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, route := withRoute(r)
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		pattern := *route
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		metrics.HTTPRequestDuration.
+			WithLabelValues(metricsMethod(r.Method), pattern, strconv.Itoa(rw.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// withRoute gives the request a place for Route to record the pattern it
+// matched, or returns the one an outer middleware already set up
+func withRoute(r *http.Request) (*http.Request, *string) {
+	if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+		return r, route
+	}
+	route := new(string)
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, route)), route
+}
+
+// Route wraps the router and tells Metrics and Tracing which pattern served the request.
+// The router sets the pattern on the request it was given, which the
+// middleware in between have replaced with copies.
+func Route(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			*route = r.Pattern
+		}
+	})
+}
+
+// metricsMethod keeps arbitrary methods sent by clients from creating new
+// label values
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
@@ -2,7 +2,7 @@ package middleware
 
 import (
 	"expensemanager/internal/i18n"
-	"log"
+	"log/slog"
 	"net/http"
 	"runtime/debug"
 	"time"
@@ -31,12 +31,11 @@ func Logger(next http.Handler) http.Handler {
 		next.ServeHTTP(rw, r)
 
 		// Log request details
-		log.Printf(
-			"%s %s %d %s",
-			r.Method,
-			r.RequestURI,
-			rw.status,
-			time.Since(start),
+		slog.InfoContext(r.Context(), "Request",
+			"method", r.Method,
+			"uri", r.RequestURI,
+			"status", rw.status,
+			"duration", time.Since(start),
 		)
 	})
 }
@@ -73,7 +72,12 @@ func Recovery(next http.Handler) http.Handler {
 		defer func() {
 			if err := recover(); err != nil {
 				// Log the error and stack trace
-				log.Printf("panic: %v\n%s", err, debug.Stack())
+				slog.ErrorContext(r.Context(), "Panic serving request",
+					"method", r.Method,
+					"uri", r.RequestURI,
+					"error", err,
+					"stack", string(debug.Stack()),
+				)
 
 				// Return 500 Internal Server Error
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -95,6 +99,12 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// streaming handlers can still flush through the logger
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	if rw.status == 0 {
 		rw.status = http.StatusOK
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// AccountExport is the personal data archive a user can download: everything
+// stored about them except secrets such as password hashes and key material
+type AccountExport struct {
+	ExportedAt    time.Time      `json:"exported_at"`
+	Account       *User          `json:"account"`
+	Expenses      []ExpenseJSON  `json:"expenses"`
+	APITokens     []APIToken     `json:"api_tokens"`
+	Passkeys      []Credential   `json:"passkeys"`
+	Identities    []Identity     `json:"linked_identities"`
+	Sessions      []Session      `json:"sessions"`
+	LoginAttempts []LoginAttempt `json:"failed_sign_ins"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Actions recorded in the audit log
+const (
+	AuditDisableUser = "disable_user"
+	AuditEnableUser  = "enable_user"
+	AuditForceReset  = "force_password_reset"
+	AuditResetTOTP   = "reset_2fa"
+	AuditDeleteUser  = "delete_user"
+)
+
+// AuditEntry records an action an administrator took. The emails are copied
+// so the entry stays readable after either account is deleted.
+type AuditEntry struct {
+	ID           int64     `json:"id"`
+	ActorID      *int64    `json:"actor_id"`
+	ActorEmail   string    `json:"actor_email"`
+	Action       string    `json:"action"`
+	TargetUserID *int64    `json:"target_user_id"`
+	TargetEmail  string    `json:"target_email"`
+	Details      string    `json:"details"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserSummary is a row in the administrators' list of accounts
+type UserSummary struct {
+	User
+	LastLoginAt  *time.Time `json:"last_login_at"`
+	ExpenseCount int        `json:"expense_count"`
+}
+
+// TableUsage is the disk space used by one database table, indexes included
+type TableUsage struct {
+	Name  string `json:"name"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes"`
+}
+
+// StorageUsage summarises the disk space used by the instance
+type StorageUsage struct {
+	DatabaseBytes int64        `json:"database_bytes"`
+	Tables        []TableUsage `json:"tables"`
+	Users         int          `json:"users"`
+	Expenses      int          `json:"expenses"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Credential is a passkey registered to a user. Only the public key is stored;
+// the private key never leaves the user's authenticator.
+type Credential struct {
+	ID              int64      `json:"id"`
+	UserID          int64      `json:"user_id"`
+	CredentialID    []byte     `json:"-"`
+	PublicKey       []byte     `json:"-"`
+	AttestationType string     `json:"-"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       uint32     `json:"-"`
+	Transports      []string   `json:"-"`
+	BackupEligible  bool       `json:"backup_eligible"`
+	BackupState     bool       `json:"backup_state"`
+	Name            string     `json:"name"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CSVHeader is the header row of the expense CSV format
+var CSVHeader = []string{"id", "date", "category", "description", "amount"}
+
+// WriteExpensesCSV writes expenses in the CSV exchange format
+func WriteExpensesCSV(w io.Writer, expenses []ExpenseJSON) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+
+	for _, e := range expenses {
+		record := []string{
+			strconv.FormatInt(e.ID, 10),
+			e.Date,
+			e.Category,
+			e.Description,
+			strconv.FormatFloat(e.Amount, 'f', 2, 64),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadExpensesCSV parses the CSV exchange format. Columns are matched by
+// header name, so files may omit the id column or reorder columns.
+func ReadExpensesCSV(r io.Reader) ([]ExpenseJSON, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"date", "category", "description", "amount"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %q column", required)
+		}
+	}
+
+	var expenses []ExpenseJSON
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+		amount, err := strconv.ParseFloat(record[columns["amount"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid amount %q", line, record[columns["amount"]])
+		}
+
+		e := ExpenseJSON{
+			Date:        record[columns["date"]],
+			Category:    record[columns["category"]],
+			Description: record[columns["description"]],
+			Amount:      amount,
+		}
+		if i, ok := columns["id"]; ok && record[i] != "" {
+			if e.ID, err = strconv.ParseInt(record[i], 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid id %q", line, record[i])
+			}
+		}
+		expenses = append(expenses, e)
+	}
+
+	return expenses, nil
+}
@@ -1,6 +1,17 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Date and timestamp layouts used by the JSON and CSV expense formats
+const (
+	DateFormat      = "2006-01-02"
+	TimestampFormat = "2006-01-02 15:04:05"
+	MonthFormat     = "2006-01"
+)
 
 // Expense represents a single expense entry
 type Expense struct {
@@ -12,6 +23,7 @@ type Expense struct {
 	Date        time.Time `json:"date"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	ClientID    string    `json:"client_id,omitempty"` // UUID assigned by an offline client
 }
 
 // Categories returns a list of valid expense categories
@@ -30,11 +42,28 @@ func Categories() []string {
 	}
 }
 
+// IsValidCategory reports whether category is one of Categories, ignoring case
+func IsValidCategory(category string) bool {
+	_, ok := CanonicalCategory(category)
+	return ok
+}
+
+// CanonicalCategory returns the entry of Categories that category names,
+// ignoring case, so "Food" is stored as "food"
+func CanonicalCategory(category string) (string, bool) {
+	for _, c := range Categories() {
+		if strings.EqualFold(category, c) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
 type Analytics struct {
-	TotalSpent     float64
-	CategoryTotals map[string]float64
-	MonthlyTotals  []MonthlyTotal
-	MonthlyAverage float64
+	TotalSpent     float64            `json:"total_spent"`
+	CategoryTotals map[string]float64 `json:"category_totals"`
+	MonthlyTotals  []MonthlyTotal     `json:"monthly_totals"`
+	MonthlyAverage float64            `json:"monthly_average"`
 }
 
 type MonthlyTotal struct {
@@ -42,6 +71,11 @@ type MonthlyTotal struct {
 	Total float64 `json:"total"`
 }
 
+type CategoryTotal struct {
+	Category string  `json:"category"`
+	Total    float64 `json:"total"`
+}
+
 type ExpenseJSON struct {
 	ID          int64   `json:"id"`
 	UserID      int64   `json:"user_id"`
@@ -50,4 +84,42 @@ type ExpenseJSON struct {
 	Category    string  `json:"category"`
 	Date        string  `json:"date"`
 	CreatedAt   string  `json:"created_at"`
+	UpdatedAt   string  `json:"updated_at,omitempty"` // RFC 3339 with sub-second precision
+	ClientID    string  `json:"client_id,omitempty"`
+}
+
+// NewExpenseJSON converts an expense to its wire format
+func NewExpenseJSON(e Expense) ExpenseJSON {
+	return ExpenseJSON{
+		ID:          e.ID,
+		UserID:      e.UserID,
+		Amount:      e.Amount,
+		Description: e.Description,
+		Category:    e.Category,
+		Date:        e.Date.Format(DateFormat),
+		CreatedAt:   e.CreatedAt.Format(TimestampFormat),
+		UpdatedAt:   e.UpdatedAt.Format(time.RFC3339Nano),
+		ClientID:    e.ClientID,
+	}
+}
+
+// ToExpense validates the wire format and converts it to an expense owned by
+// userID. The ID and creation time are not carried over.
+func (e ExpenseJSON) ToExpense(userID int64) (Expense, error) {
+	date, err := time.Parse(DateFormat, e.Date)
+	if err != nil {
+		return Expense{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", e.Date)
+	}
+	category, ok := CanonicalCategory(e.Category)
+	if !ok {
+		return Expense{}, fmt.Errorf("invalid category %q", e.Category)
+	}
+
+	return Expense{
+		UserID:      userID,
+		Amount:      e.Amount,
+		Description: e.Description,
+		Category:    category,
+		Date:        date,
+	}, nil
 }
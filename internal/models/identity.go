@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Identity links a user to their account at an OpenID Connect identity
+// provider, so they can sign in there instead of with a password
+type Identity struct {
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
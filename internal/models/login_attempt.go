@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Account lockout after repeated failed sign-ins
+const (
+	MaxFailedLogins = 10
+	LockoutDuration = 15 * time.Minute
+)
+
+// Reasons a sign-in attempt failed
+const (
+	LoginFailedPassword     = "password"      // wrong password
+	LoginFailedSecondFactor = "second_factor" // wrong TOTP or recovery code
+	LoginFailedLocked       = "locked"        // tried while locked out
+)
+
+// LoginAttempt is a failed sign-in to an account, kept so the owner can spot
+// someone guessing their password
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
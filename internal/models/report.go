@@ -0,0 +1,15 @@
+package models
+
+// YearlyReport summarises a user's spending for one calendar year
+type YearlyReport struct {
+	Year           int             `json:"year"`
+	Total          float64         `json:"total"`
+	MonthlyAverage float64         `json:"monthly_average"`
+	MonthlyTotals  []MonthlyTotal  `json:"monthly_totals"`
+	CategoryTotals []CategoryTotal `json:"category_totals"`
+}
+
+// ImportResult reports the outcome of a bulk expense import
+type ImportResult struct {
+	Imported int `json:"imported"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Session is a browser session kept on the server. The cookie only holds a
+// random token; the session's values live in the database so a session can
+// be listed and revoked.
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     *int64    `json:"user_id"`
+	Data       []byte    `json:"-"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Whether this is the session making the request
+	Current bool `json:"current"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import "regexp"
+
+// Sync actions
+const (
+	SyncUpsert = "upsert"
+	SyncDelete = "delete"
+)
+
+// Sync result statuses
+const (
+	SyncApplied  = "applied"  // the operation was applied, or had already been
+	SyncConflict = "conflict" // the server copy changed since the client last saw it
+	SyncInvalid  = "invalid"  // the operation was rejected and will never apply
+)
+
+// MaxSyncOperations bounds the size of a single sync batch
+const MaxSyncOperations = 500
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// IsValidUUID reports whether s is a UUID in canonical textual form
+func IsValidUUID(s string) bool {
+	return uuidPattern.MatchString(s)
+}
+
+// SyncOperation is a change recorded by an offline client. The client
+// generates ClientID once per expense, so replaying an operation is safe.
+type SyncOperation struct {
+	ClientID string      `json:"client_id"`
+	Action   string      `json:"action"`
+	Expense  ExpenseJSON `json:"expense"`
+	// BaseUpdatedAt is the server's updated_at the client based its change
+	// on; it is empty for expenses created offline
+	BaseUpdatedAt string `json:"base_updated_at,omitempty"`
+}
+
+// SyncRequest is a batch of offline operations, applied in order
+type SyncRequest struct {
+	Operations []SyncOperation `json:"operations"`
+}
+
+// SyncResult reports the outcome of one operation. For applied upserts and
+// for conflicts Expense holds the server's current copy.
+type SyncResult struct {
+	ClientID string       `json:"client_id"`
+	Status   string       `json:"status"`
+	Expense  *ExpenseJSON `json:"expense,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// SyncResponse answers a SyncRequest with one result per operation
+type SyncResponse struct {
+	Results []SyncResult `json:"results"`
+}
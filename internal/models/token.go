@@ -0,0 +1,49 @@
+package models
+
+import (
+	"net/http"
+	"time"
+)
+
+// API token scopes
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APITokenPrefix marks personal access tokens so they are easy to recognise
+const APITokenPrefix = "em_"
+
+// APIToken is a personal access token used by scripts and API clients.
+// Only a hash of the token is stored; the plaintext is shown once on creation.
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Scope      string     `json:"scope"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Scopes returns the list of valid token scopes
+func Scopes() []string {
+	return []string{ScopeRead, ScopeWrite}
+}
+
+// IsExpired reports whether the token has passed its expiry time
+func (t *APIToken) IsExpired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// Allows reports whether the token's scope permits the given HTTP method.
+// Read tokens may only issue safe methods; write tokens may do anything.
+func (t *APIToken) Allows(method string) bool {
+	switch t.Scope {
+	case ScopeWrite:
+		return true
+	case ScopeRead:
+		return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	}
+	return false
+}
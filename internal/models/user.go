@@ -3,12 +3,89 @@ package models
 import "time"
 
 type User struct {
-	ID        int64     `json:"id"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"` // "-" means this field won't be included in JSON
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              int64      `json:"id"`
+	Email           string     `json:"email"`
+	PendingEmail    string     `json:"pending_email,omitempty"` // new address awaiting confirmation
+	Password        string     `json:"-"`                       // "-" means this field won't be included in JSON
+	Name            string     `json:"name"`
+	Role            string     `json:"role"`
+	SessionVersion  int        `json:"-"` // bumped to sign out every existing session
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	TOTPSecret      string     `json:"-"`
+	TOTPEnabledAt   *time.Time `json:"-"`
+	LockedUntil     *time.Time `json:"-"`           // set after too many failed sign-ins
+	DisabledAt      *time.Time `json:"disabled_at"` // set by an administrator to block the account
+	// Set by an administrator; no way of signing in works until the password is reset
+	MustResetPassword bool      `json:"must_reset_password"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// EmailVerified reports whether the user has confirmed their email address
+func (u *User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// TOTPEnabled reports whether the user signs in with a second factor
+func (u *User) TOTPEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// Roles a user can have. Everyone manages their own expenses; administrators
+// also manage the instance and other accounts.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// HasRole reports whether the user may act in the given role. Every user has
+// the user role.
+func (u *User) HasRole(role string) bool {
+	return role == RoleUser || u.Role == role
+}
+
+// IsAdmin reports whether the user administers the instance
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Disabled reports whether an administrator has blocked the account
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
+}
+
+// NoPassword is stored instead of a password hash for accounts that cannot
+// sign in with a password, such as those created by single sign-on
+const NoPassword = "!"
+
+// ReauthWindow is how long after signing in a user without a password may
+// confirm changes that others need the current password for
+const ReauthWindow = 5 * time.Minute
+
+// HasPassword reports whether the user can sign in with a password
+func (u *User) HasPassword() bool {
+	return u.Password != NoPassword
+}
+
+// Locked reports whether sign-in is refused because of recent failures
+func (u *User) Locked(now time.Time) bool {
+	return u.LockedUntil != nil && now.Before(*u.LockedUntil)
+}
+
+// Policies for what accounts with an unverified email address may do
+const (
+	UnverifiedAllow    = "allow"     // full access
+	UnverifiedReadOnly = "read-only" // may sign in and look, but not change anything
+	UnverifiedBlock    = "block"     // may only verify the address or sign out
+)
+
+// IsValidUnverifiedPolicy reports whether policy is one of the known policies
+func IsValidUnverifiedPolicy(policy string) bool {
+	switch policy {
+	case UnverifiedAllow, UnverifiedReadOnly, UnverifiedBlock:
+		return true
+	}
+	return false
 }
 
 type LoginForm struct {
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Purposes of single-use user tokens
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeEmailVerify   = "email_verify"
+	TokenPurposeEmailChange   = "email_change"
+)
+
+// How long emailed links stay valid
+const (
+	PasswordResetTTL = time.Hour
+	EmailVerifyTTL   = 48 * time.Hour
+)
+
+// UserToken is a single-use token sent to a user by email, such as a
+// password reset or email verification link. Only a hash of the token is stored.
+type UserToken struct {
+	ID        int64
+	UserID    int64
+	Purpose   string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,211 @@
+package openapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Version is the OpenAPI specification version the documents conform to
+const Version = "3.0.3"
+
+// Document is the root of an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers,omitempty"`
+	Paths      map[string]*PathItem  `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []SecurityRequirement `json:"security,omitempty"`
+}
+
+// Info holds the API metadata
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Server describes a base URL the API is served from
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations available on a single path
+type PathItem struct {
+	Get    *Operation `json:"get,omitempty"`
+	Post   *Operation `json:"post,omitempty"`
+	Put    *Operation `json:"put,omitempty"`
+	Patch  *Operation `json:"patch,omitempty"`
+	Delete *Operation `json:"delete,omitempty"`
+}
+
+// Methods returns the methods the path has operations for
+func (p *PathItem) Methods() []string {
+	var methods []string
+	for _, op := range []struct {
+		method    string
+		operation *Operation
+	}{
+		{"GET", p.Get},
+		{"POST", p.Post},
+		{"PUT", p.Put},
+		{"PATCH", p.Patch},
+		{"DELETE", p.Delete},
+	} {
+		if op.operation != nil {
+			methods = append(methods, op.method)
+		}
+	}
+	return methods
+}
+
+// Operation describes a single API operation on a path
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []SecurityRequirement `json:"security,omitempty"`
+}
+
+// Parameter describes a query or path parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the payload accepted by an operation
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response describes a single response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType binds a schema to a content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds reusable schemas and security schemes
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes an authentication mechanism
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// SecurityRequirement maps security scheme names to required scopes
+type SecurityRequirement map[string][]string
+
+// Anonymous marks an operation as callable without credentials
+var Anonymous = []SecurityRequirement{{}}
+
+// NewDocument creates an empty document with the given title and version
+func NewDocument(title, version string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:   title,
+			Version: version,
+		},
+		Paths: make(map[string]*PathItem),
+		Components: Components{
+			Schemas:         make(map[string]*Schema),
+			SecuritySchemes: make(map[string]*SecurityScheme),
+		},
+	}
+}
+
+// AddOperation registers an operation for the given method and path
+func (d *Document) AddOperation(method, path string, op *Operation) {
+	item, ok := d.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+
+	switch strings.ToUpper(method) {
+	case "GET":
+		item.Get = op
+	case "POST":
+		item.Post = op
+	case "PUT":
+		item.Put = op
+	case "PATCH":
+		item.Patch = op
+	case "DELETE":
+		item.Delete = op
+	default:
+		panic(fmt.Sprintf("openapi: unsupported method %q", method))
+	}
+}
+
+// VerifyRoutes checks that the documented operations and the registered mux
+// patterns describe the same set of endpoints. Each pattern must name its
+// method ("GET /api/x") and may use {name} wildcards, exactly like OpenAPI
+// paths; a pattern without a method matches no documented operation.
+func (d *Document) VerifyRoutes(patterns []string) error {
+	registered := make(map[string]bool, len(patterns))
+	for _, pattern := range patterns {
+		registered[strings.TrimSuffix(pattern, "{$}")] = true
+	}
+	documented := d.operations()
+
+	var undocumented, unregistered []string
+	for route := range registered {
+		if !documented[route] {
+			undocumented = append(undocumented, route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			unregistered = append(unregistered, route)
+		}
+	}
+
+	if len(undocumented) == 0 && len(unregistered) == 0 {
+		return nil
+	}
+
+	sort.Strings(undocumented)
+	sort.Strings(unregistered)
+	var problems []string
+	if len(undocumented) > 0 {
+		problems = append(problems, "registered but not documented: "+strings.Join(undocumented, ", "))
+	}
+	if len(unregistered) > 0 {
+		problems = append(problems, "documented but not registered: "+strings.Join(unregistered, ", "))
+	}
+	return fmt.Errorf("openapi spec and routes drifted apart (%s)", strings.Join(problems, "; "))
+}
+
+// operations returns every documented operation as "METHOD /path"
+func (d *Document) operations() map[string]bool {
+	operations := make(map[string]bool)
+	for path, item := range d.Paths {
+		for _, method := range item.Methods() {
+			operations[method+" "+path] = true
+		}
+	}
+	return operations
+}
@@ -0,0 +1,184 @@
+// Package passkey connects accounts and their stored credentials to the
+// WebAuthn relying party that runs passkey registration and sign-in.
+//
+// The ceremonies themselves are plain HTTP handlers in the handlers package;
+// this package only holds the pieces that translate between the database
+// models and the WebAuthn library, so they can be exercised with a software
+// authenticator without a browser.
+package passkey
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"expensemanager/internal/models"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// DisplayName is the relying party name shown by authenticators
+const DisplayName = "Expense Manager"
+
+// CeremonyTimeout is how long the user has to complete a ceremony
+const CeremonyTimeout = 5 * time.Minute
+
+// New returns the relying party for the server's public base URL. The
+// relying party ID is the host name, which passkeys are bound to, and only
+// the base URL's origin is accepted in responses.
+func New(baseURL string) (*webauthn.WebAuthn, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: CeremonyTimeout, TimeoutUVD: CeremonyTimeout}
+	return webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: DisplayName,
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+		// Passkeys must be discoverable so sign-in needs no email address, and
+		// must verify the user so they can stand in for a password and a second factor
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// UserHandle returns the opaque WebAuthn user handle for an account
+func UserHandle(userID int64) []byte {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return handle
+}
+
+// UserID returns the account a user handle belongs to
+func UserID(handle []byte) (int64, error) {
+	if len(handle) != 8 {
+		return 0, fmt.Errorf("invalid user handle")
+	}
+	return int64(binary.BigEndian.Uint64(handle)), nil
+}
+
+// Lookup returns the handler that finds the account a discoverable sign-in
+// names through its user handle, loading it with load. load returns nil for an
+// account that does not exist.
+func Lookup(load func(userID int64) (*User, error)) webauthn.DiscoverableUserHandler {
+	return func(_, handle []byte) (webauthn.User, error) {
+		userID, err := UserID(handle)
+		if err != nil {
+			return nil, err
+		}
+		user, err := load(userID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, fmt.Errorf("no user %d", userID)
+		}
+		return user, nil
+	}
+}
+
+// ErrCloned is returned for a passkey whose signature counter went backwards
+var ErrCloned = errors.New("signature counter went backwards")
+
+// CheckCounter refuses a credential used in a sign-in when its counter shows
+// the key may have been copied
+func CheckCounter(c *webauthn.Credential) error {
+	if c.Authenticator.CloneWarning {
+		return ErrCloned
+	}
+	return nil
+}
+
+// User presents an account and its passkeys to the WebAuthn library
+type User struct {
+	Account     *models.User
+	Credentials []models.Credential
+}
+
+// WebAuthnID implements webauthn.User
+func (u *User) WebAuthnID() []byte {
+	return UserHandle(u.Account.ID)
+}
+
+// WebAuthnName implements webauthn.User
+func (u *User) WebAuthnName() string {
+	return u.Account.Email
+}
+
+// WebAuthnDisplayName implements webauthn.User
+func (u *User) WebAuthnDisplayName() string {
+	if u.Account.Name != "" {
+		return u.Account.Name
+	}
+	return u.Account.Email
+}
+
+// WebAuthnCredentials implements webauthn.User
+func (u *User) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.Credentials))
+	for i, c := range u.Credentials {
+		credentials[i] = toWebAuthn(c)
+	}
+	return credentials
+}
+
+// Exclusions lists the user's passkeys so an authenticator that already holds
+// one is not registered twice
+func (u *User) Exclusions() []protocol.CredentialDescriptor {
+	descriptors := make([]protocol.CredentialDescriptor, len(u.Credentials))
+	for i, c := range u.Credentials {
+		descriptors[i] = toWebAuthn(c).Descriptor()
+	}
+	return descriptors
+}
+
+// NewCredential converts a credential produced by a registration ceremony
+// into the record stored for the user
+func NewCredential(userID int64, name string, c *webauthn.Credential) *models.Credential {
+	transports := make([]string, len(c.Transport))
+	for i, t := range c.Transport {
+		transports[i] = string(t)
+	}
+	return &models.Credential{
+		UserID:          userID,
+		CredentialID:    c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		AAGUID:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  c.Flags.BackupEligible,
+		BackupState:     c.Flags.BackupState,
+		Name:            name,
+	}
+}
+
+func toWebAuthn(c models.Credential) webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+	for i, t := range c.Transports {
+		transports[i] = protocol.AuthenticatorTransport(t)
+	}
+	return webauthn.Credential{
+		ID:              c.CredentialID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: c.BackupEligible,
+			BackupState:    c.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:    c.AAGUID,
+			SignCount: c.SignCount,
+		},
+	}
+}
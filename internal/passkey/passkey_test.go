@@ -0,0 +1,281 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"expensemanager/internal/models"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testBaseURL = "https://expenses.example.com"
+	testRPID    = "expenses.example.com"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+var encode = base64.RawURLEncoding.EncodeToString
+
+// authenticator is a software passkey holding one key pair for one account,
+// answering ceremonies the way a browser would pass them on
+type authenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	handle    []byte
+	signCount uint32
+}
+
+func newAuthenticator(t *testing.T, userID int64) *authenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &authenticator{key: key, id: id, handle: UserHandle(userID)}
+}
+
+// authData builds authenticator data for the relying party
+func (a *authenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, ceremony, challenge string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    testBaseURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create
+func (a *authenticator) create(t *testing.T, creation *protocol.CredentialCreation) []byte {
+	t.Helper()
+	publicKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(attested, a.id...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(flagUserPresent|flagUserVerified|flagAttested, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    encode(clientData(t, "webauthn.create", creation.Response.Challenge.String())),
+		"attestationObject": encode(attestation),
+	})
+}
+
+// get answers navigator.credentials.get, naming the account by handle
+func (a *authenticator) get(t *testing.T, assertion *protocol.CredentialAssertion, handle []byte) []byte {
+	t.Helper()
+	a.signCount++
+	authData := a.authData(flagUserPresent|flagUserVerified, nil)
+	client := clientData(t, "webauthn.get", assertion.Response.Challenge.String())
+
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.response(t, map[string]string{
+		"clientDataJSON":    encode(client),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(handle),
+	})
+}
+
+func (a *authenticator) response(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{
+		"id":       encode(a.id),
+		"rawId":    encode(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func post(body []byte) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, testBaseURL+"/passkeys", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// register runs a registration ceremony and returns the stored credential
+func register(t *testing.T, rp *webauthn.WebAuthn, user *User, a *authenticator) models.Credential {
+	t.Helper()
+	creation, session, err := rp.BeginRegistration(user, webauthn.WithExclusions(user.Exclusions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := rp.FinishRegistration(user, *session, post(a.create(t, creation)))
+	if err != nil {
+		t.Fatalf("registration failed: %v", describe(err))
+	}
+	return *NewCredential(user.Account.ID, "Test key", credential)
+}
+
+// login runs a discoverable sign-in ceremony with the users known by ID
+func login(t *testing.T, rp *webauthn.WebAuthn, users map[int64]*User, a *authenticator, handle []byte) (webauthn.User, *webauthn.Credential, error) {
+	t.Helper()
+	assertion, session, err := rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := Lookup(func(userID int64) (*User, error) {
+		return users[userID], nil
+	})
+	return rp.FinishPasskeyLogin(lookup, *session, post(a.get(t, assertion, handle)))
+}
+
+// describe includes the details the library keeps apart from the message
+func describe(err error) string {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) {
+		return protocolErr.Error() + ": " + protocolErr.DevInfo
+	}
+	return err.Error()
+}
+
+func newRelyingParty(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+	rp, err := New(testBaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func TestRegisterAndSignIn(t *testing.T) {
+	rp := newRelyingParty(t)
+	user := &User{Account: &models.User{ID: 42, Email: "ana@example.com"}}
+	a := newAuthenticator(t, user.Account.ID)
+
+	stored := register(t, rp, user, a)
+	if !bytes.Equal(stored.CredentialID, a.id) {
+		t.Fatalf("stored credential ID %x, want %x", stored.CredentialID, a.id)
+	}
+	user.Credentials = append(user.Credentials, stored)
+
+	found, credential, err := login(t, rp, map[int64]*User{42: user}, a, a.handle)
+	if err != nil {
+		t.Fatalf("sign-in failed: %v", describe(err))
+	}
+	if got := found.(*User).Account.ID; got != 42 {
+		t.Errorf("signed in as user %d, want 42", got)
+	}
+	if err := CheckCounter(credential); err != nil {
+		t.Errorf("fresh counter refused: %v", err)
+	}
+	if credential.Authenticator.SignCount != a.signCount {
+		t.Errorf("sign count %d, want %d", credential.Authenticator.SignCount, a.signCount)
+	}
+}
+
+func TestSignInRefusesCounterGoingBackwards(t *testing.T) {
+	rp := newRelyingParty(t)
+	user := &User{Account: &models.User{ID: 7, Email: "ben@example.com"}}
+	a := newAuthenticator(t, user.Account.ID)
+
+	stored := register(t, rp, user, a)
+	// The server has seen a higher count than the key is about to send, as
+	// when a copy of the key has been used in the meantime
+	stored.SignCount = 10
+	user.Credentials = append(user.Credentials, stored)
+
+	_, credential, err := login(t, rp, map[int64]*User{7: user}, a, a.handle)
+	if err != nil {
+		t.Fatalf("sign-in failed: %v", describe(err))
+	}
+	if err := CheckCounter(credential); !errors.Is(err, ErrCloned) {
+		t.Errorf("CheckCounter() = %v, want ErrCloned", err)
+	}
+}
+
+func TestSignInRefusesWrongUserHandle(t *testing.T) {
+	rp := newRelyingParty(t)
+	owner := &User{Account: &models.User{ID: 1, Email: "owner@example.com"}}
+	other := &User{Account: &models.User{ID: 2, Email: "other@example.com"}}
+	a := newAuthenticator(t, owner.Account.ID)
+	owner.Credentials = append(owner.Credentials, register(t, rp, owner, a))
+	users := map[int64]*User{1: owner, 2: other}
+
+	tests := []struct {
+		name   string
+		handle []byte
+	}{
+		{"malformed handle", []byte("not-a-user-id")},
+		{"unknown account", UserHandle(99)},
+		{"another account", UserHandle(2)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := login(t, rp, users, a, tt.handle); err == nil {
+				t.Error("sign-in succeeded")
+			}
+		})
+	}
+}
+
+func TestUserID(t *testing.T) {
+	id, err := UserID(UserHandle(1234567))
+	if err != nil || id != 1234567 {
+		t.Errorf("UserID(UserHandle(1234567)) = %d, %v", id, err)
+	}
+	for _, handle := range [][]byte{nil, {1, 2, 3}, make([]byte, 9)} {
+		if _, err := UserID(handle); err == nil {
+			t.Errorf("UserID(%x) accepted an invalid handle", handle)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package passwords decides whether a password is acceptable: long enough,
+// not too long for bcrypt, and not one of the passwords attackers try first.
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxBytes is the longest password bcrypt hashes in full. Longer passwords
+// would be silently truncated, so they are refused instead.
+const MaxBytes = 72
+
+// Reasons a password is refused
+const (
+	TooShort = "too_short"
+	TooLong  = "too_long"
+	Common   = "common"
+	Breached = "breached"
+)
+
+//go:embed common.txt
+var commonList string
+
+// Error explains why a password was refused
+type Error struct {
+	Reason    string
+	MinLength int
+}
+
+func (e *Error) Error() string {
+	switch e.Reason {
+	case TooShort:
+		return fmt.Sprintf("password must be at least %d characters", e.MinLength)
+	case TooLong:
+		return fmt.Sprintf("password must be at most %d bytes", MaxBytes)
+	case Common:
+		return "password is too common"
+	case Breached:
+		return "password has appeared in a data breach"
+	}
+	return "password is not allowed: " + e.Reason
+}
+
+// Policy checks new passwords. The common passwords are matched ignoring case,
+// and also with the variations people typically make of them undone, such as
+// a trailing number, year or symbol or letters written as look-alike digits.
+// Breached passwords, loaded from a corpus with LoadBreached, are matched by
+// the first 32 bits of their SHA-1 hash, the same prefixes that k-anonymity
+// lookups such as Have I Been Pwned use, so the list stays small and holds no
+// passwords in the clear. A prefix match may rarely refuse a password that was
+// never breached, which only means choosing another one.
+type Policy struct {
+	MinLength int
+
+	common   map[string]bool
+	breached []uint32
+}
+
+// NewPolicy returns a policy with the given minimum length in characters,
+// loaded with the bundled list of common passwords. It knows no breached
+// passwords until LoadBreached is called.
+func NewPolicy(minLength int) (*Policy, error) {
+	if minLength < 1 || minLength > MaxBytes {
+		return nil, fmt.Errorf("minimum password length must be between 1 and %d", MaxBytes)
+	}
+
+	p := &Policy{MinLength: minLength, common: make(map[string]bool)}
+	for _, line := range strings.Split(commonList, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			p.common[strings.ToLower(line)] = true
+		}
+	}
+	return p, nil
+}
+
+// LoadBreached adds hash prefixes of breached passwords, one per line. Each
+// line starts with at least 8 hex digits of a SHA-1 hash, so full hashes and
+// the "HASH:COUNT" lines of downloaded breach corpora work too. Blank lines
+// and lines starting with # are skipped.
+func (p *Policy) LoadBreached(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if len(text) < 8 {
+			return fmt.Errorf("line %d: expected a SHA-1 hash prefix", line)
+		}
+		prefix, err := strconv.ParseUint(text[:8], 16, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: expected a SHA-1 hash prefix", line)
+		}
+		p.breached = append(p.breached, uint32(prefix))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	slices.Sort(p.breached)
+	p.breached = slices.Compact(p.breached)
+	return nil
+}
+
+// leet undoes the digits and symbols written for look-alike letters
+var leet = strings.NewReplacer("@", "a", "4", "a", "3", "e", "1", "i", "0", "o", "$", "s", "5", "s", "7", "t")
+
+// isCommon reports whether the password is a common one, possibly with a
+// trailing run of digits and symbols, as in "Dragon2024!", or spelled with
+// look-alike digits, as in "p@ssw0rd"
+func (p *Policy) isCommon(password string) bool {
+	lower := strings.ToLower(password)
+	if p.common[lower] {
+		return true
+	}
+	stem := strings.TrimRightFunc(lower, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+	return stem != "" && (p.common[stem] || p.common[leet.Replace(stem)])
+}
+
+// Check returns an *Error if the password breaks the policy, or nil
+func (p *Policy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &Error{Reason: TooShort, MinLength: p.MinLength}
+	}
+	if len(password) > MaxBytes {
+		return &Error{Reason: TooLong, MinLength: p.MinLength}
+	}
+	if p.isCommon(password) {
+		return &Error{Reason: Common, MinLength: p.MinLength}
+	}
+
+	sum := sha1.Sum([]byte(password))
+	if _, found := slices.BinarySearch(p.breached, binary.BigEndian.Uint32(sum[:4])); found {
+		return &Error{Reason: Breached, MinLength: p.MinLength}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Package ratelimit slows down repeated failures such as password guessing.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Backoff counts recent failures per key, for example a client IP or an
+// account, and makes each one wait exponentially longer before the next
+// attempt. Keys are forgotten once they have been quiet for the forget period.
+//
+// Backoff keeps its state in memory, so each server instance limits on its
+// own. It is safe for concurrent use.
+type Backoff struct {
+	free   int
+	base   time.Duration
+	max    time.Duration
+	forget time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type entry struct {
+	failures int
+	last     time.Time
+}
+
+// NewBackoff allows free failures without delay, then waits base after the
+// next one and doubles the wait with each further failure, up to max
+func NewBackoff(free int, base, max, forget time.Duration) *Backoff {
+	return &Backoff{
+		free:    free,
+		base:    base,
+		max:     max,
+		forget:  forget,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Wait returns how long the key must wait before its next attempt, or zero
+// if it may try now
+func (b *Backoff) Wait(key string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e := b.lookup(key)
+	if e == nil || e.failures < b.free {
+		return 0
+	}
+
+	delay := b.max
+	if shift := e.failures - b.free; shift < 32 && b.base<<shift < b.max {
+		delay = b.base << shift
+	}
+	if wait := e.last.Add(delay).Sub(b.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Failure records a failed attempt for the key
+func (b *Backoff) Failure(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e := b.lookup(key)
+	if e == nil {
+		e = &entry{}
+		b.entries[key] = e
+	}
+	e.failures++
+	e.last = b.now()
+}
+
+// Success forgets the failures recorded for the key
+func (b *Backoff) Success(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.entries, key)
+}
+
+// lookup returns the live entry for key, dropping expired entries along the
+// way. The caller must hold b.mu.
+func (b *Backoff) lookup(key string) *entry {
+	now := b.now()
+	if now.Sub(b.lastSweep) > b.forget {
+		for k, e := range b.entries {
+			if now.Sub(e.last) > b.forget {
+				delete(b.entries, k)
+			}
+		}
+		b.lastSweep = now
+	}
+
+	e, ok := b.entries[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(e.last) > b.forget {
+		delete(b.entries, key)
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,225 @@
+// Package sessionstore keeps browser sessions on the server so they can be
+// listed and revoked.
+package sessionstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/gob"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"expensemanager/internal/database"
+	"expensemanager/internal/models"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// touchInterval is how stale a session's last-seen time may get before a
+// request updates it, so that every request does not write to the database
+const touchInterval = time.Minute
+
+// SweepInterval is how often expired sessions are deleted
+const SweepInterval = 15 * time.Minute
+
+// PostgresStore is a sessions.Store that keeps session values in the
+// sessions table. The cookie holds a signed random token whose hash
+// identifies the row.
+//
+// A session expires when it has not been used for the idle timeout or once
+// it is older than its lifetime, whichever comes first. Signing in or out
+// starts a new session with a new token, so a token planted before sign-in
+// is worthless afterwards. The values are carried over, so signing in must
+// also reset the CSRF token; see middleware.ResetCSRFToken.
+type PostgresStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+	// ClientIP returns the address recorded for a request. It defaults to
+	// the remote address of the connection.
+	ClientIP func(r *http.Request) string
+
+	db          *database.DB
+	idleTimeout time.Duration
+	lifetime    time.Duration
+}
+
+// NewPostgresStore creates a store whose cookies are signed, and optionally
+// encrypted, with keyPairs as in sessions.NewCookieStore
+func NewPostgresStore(db *database.DB, idleTimeout, lifetime time.Duration, keyPairs ...[]byte) *PostgresStore {
+	s := &PostgresStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: int(lifetime.Seconds()),
+		},
+		ClientIP:    remoteIP,
+		db:          db,
+		idleTimeout: idleTimeout,
+		lifetime:    lifetime,
+	}
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(s.Options.MaxAge)
+		}
+	}
+	return s
+}
+
+// Get returns the named session, cached for the rest of the request
+func (s *PostgresStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie. A missing, invalid,
+// revoked or expired session gives a new empty one.
+func (s *PostgresStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	stored, err := s.db.GetSessionByHash(database.HashSessionToken(token))
+	if err != nil || stored == nil {
+		return session, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(stored.Data)).Decode(&session.Values); err != nil {
+		return session, err
+	}
+	session.ID = token
+	session.IsNew = false
+
+	now := time.Now()
+	if now.Sub(stored.LastSeenAt) >= touchInterval {
+		if err := s.db.TouchSession(stored.ID, s.ClientIP(r), r.UserAgent(), s.expiry(stored.CreatedAt, now)); err != nil {
+			slog.ErrorContext(r.Context(), "Error updating session last use", "session_id", stored.ID, "error", err)
+		}
+	}
+	return session, nil
+}
+
+// Save writes the session's values to the database and sets the cookie. A
+// negative MaxAge deletes the session instead.
+func (s *PostgresStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.db.DeleteSessionByHash(database.HashSessionToken(session.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+	var userID *int64
+	if id, ok := session.Values["user_id"].(int64); ok {
+		userID = &id
+	}
+
+	if session.ID != "" {
+		tokenHash := database.HashSessionToken(session.ID)
+		err := s.db.UpdateSessionData(tokenHash, userID, data.Bytes())
+		if err == nil {
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+		// The user signed in or out, or the session ended meanwhile
+		if err := s.db.DeleteSessionByHash(tokenHash); err != nil {
+			return err
+		}
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	stored := &models.Session{
+		UserID:    userID,
+		Data:      data.Bytes(),
+		IP:        s.ClientIP(r),
+		UserAgent: r.UserAgent(),
+		ExpiresAt: s.expiry(now, now),
+	}
+	if err := s.db.CreateSession(stored, database.HashSessionToken(token)); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), token, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	session.ID = token
+	session.IsNew = false
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// SweepExpired deletes expired sessions every interval until ctx is done.
+// Expired sessions are already refused when read; the sweep only keeps the
+// table from growing.
+func (s *PostgresStore) SweepExpired(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.db.WithContext(ctx).DeleteExpiredSessions()
+			if err != nil {
+				slog.ErrorContext(ctx, "Error deleting expired sessions", "error", err)
+			} else if deleted > 0 {
+				slog.DebugContext(ctx, "Deleted expired sessions", "count", deleted)
+			}
+		}
+	}
+}
+
+// expiry returns when a session created at created and last used at lastSeen
+// expires
+func (s *PostgresStore) expiry(created, lastSeen time.Time) time.Time {
+	idle := lastSeen.Add(s.idleTimeout)
+	if absolute := created.Add(s.lifetime); absolute.Before(idle) {
+		return absolute
+	}
+	return idle
+}
+
+// generateToken returns 32 random bytes encoded as URL-safe base64
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// remoteIP returns the address of the connection the request came in on
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,208 @@
+// Package sso signs users in with OpenID Connect identity providers. It runs
+// the authorization code flow with PKCE, checks state and nonce, and verifies
+// the ID token against the provider's published keys. Linking the verified
+// identity to an account is left to the handlers.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"expensemanager/internal/config"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// LoginTimeout is how long the user has to sign in at the provider
+const LoginTimeout = 10 * time.Minute
+
+// httpClient talks to identity providers
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// ErrLoginExpired means the sign-in was started too long ago, or in another
+// browser
+var ErrLoginExpired = errors.New("sso: sign-in expired")
+
+// Provider is an identity provider users can sign in with. Its discovery
+// document is fetched on first use, so a provider that is down does not stop
+// the server from starting.
+type Provider struct {
+	ID            string
+	Name          string
+	AutoProvision bool
+
+	issuer string
+	oauth  oauth2.Config
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// New returns the provider for the configuration. The provider redirects
+// back to /login/sso/{id}/callback on the server's public base URL.
+func New(cfg config.OIDCProviderConfig, baseURL string) *Provider {
+	return &Provider{
+		ID:            cfg.ID,
+		Name:          cfg.Name,
+		AutoProvision: cfg.AutoProvision,
+		issuer:        cfg.Issuer,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  strings.TrimRight(baseURL, "/") + "/login/sso/" + cfg.ID + "/callback",
+			Scopes:       cfg.Scopes,
+		},
+	}
+}
+
+// discover returns the provider's endpoints and keys, fetching the discovery
+// document the first time. Failures are not remembered so a later sign-in
+// tries again.
+func (p *Provider) discover() (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		// The provider refreshes its keys with this context long after the
+		// request that discovered it, so it must not be a request's context
+		provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), httpClient), p.issuer)
+		if err != nil {
+			return nil, fmt.Errorf("sso: discovering %s: %w", p.issuer, err)
+		}
+		p.provider = provider
+	}
+	return p.provider, nil
+}
+
+// Login is what the browser's session remembers between sending the user to
+// the provider and their return
+type Login struct {
+	Provider  string    `json:"provider"`
+	State     string    `json:"state"`
+	Nonce     string    `json:"nonce"`
+	Verifier  string    `json:"verifier"`
+	Language  string    `json:"language"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Begin starts a sign-in in the given language. It returns the provider's authorization URL to send
+// the user to, and the login to keep in their session.
+func (p *Provider) Begin(language string) (string, *Login, error) {
+	provider, err := p.discover()
+	if err != nil {
+		return "", nil, err
+	}
+
+	login := &Login{
+		Provider:  p.ID,
+		State:     randomString(),
+		Nonce:     randomString(),
+		Verifier:  oauth2.GenerateVerifier(),
+		Language:  language,
+		StartedAt: time.Now(),
+	}
+	config := p.oauth
+	config.Endpoint = provider.Endpoint()
+	url := config.AuthCodeURL(login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier))
+	return url, login, nil
+}
+
+// Identity is a user as vouched for by an identity provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Finish completes a sign-in with the state and code the provider redirected
+// back with. It exchanges the code for tokens and verifies the ID token's
+// signature, issuer, audience, expiry and nonce.
+func (p *Provider) Finish(ctx context.Context, login *Login, state, code string) (*Identity, error) {
+	if login == nil || login.Provider != p.ID || time.Since(login.StartedAt) > LoginTimeout {
+		return nil, ErrLoginExpired
+	}
+	if state == "" || state != login.State {
+		return nil, errors.New("sso: state does not match")
+	}
+
+	provider, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	ctx = oidc.ClientContext(ctx, httpClient)
+	config := p.oauth
+	config.Endpoint = provider.Endpoint()
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("sso: exchanging code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("sso: token response has no ID token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.oauth.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("sso: verifying ID token: %w", err)
+	}
+	if idToken.Nonce != login.Nonce {
+		return nil, errors.New("sso: nonce does not match")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     any    `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("sso: reading ID token claims: %w", err)
+	}
+
+	identity := &Identity{
+		Subject: idToken.Subject,
+		Email:   claims.Email,
+		// Some providers send the flag as a string
+		EmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+		Name:          claims.Name,
+	}
+	if identity.Name == "" {
+		identity.Name = claims.PreferredUsername
+	}
+	if identity.Name == "" {
+		identity.Name, _, _ = strings.Cut(identity.Email, "@")
+	}
+	return identity, nil
+}
+
+// EncodeLogin serializes a login for the session
+func EncodeLogin(login *Login) ([]byte, error) {
+	return json.Marshal(login)
+}
+
+// DecodeLogin reads a login stored with EncodeLogin
+func DecodeLogin(data []byte) (*Login, error) {
+	login := &Login{}
+	if err := json.Unmarshal(data, login); err != nil {
+		return nil, err
+	}
+	return login, nil
+}
+
+// randomString returns 32 random bytes encoded for use in a URL
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
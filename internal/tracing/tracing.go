@@ -0,0 +1,84 @@
+// Package tracing sets up OpenTelemetry tracing. Until Setup is called with an
+// endpoint, spans are no-ops that cost next to nothing.
+package tracing
+
+import (
+	"context"
+	"html/template"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the spans created by this application
+const instrumentation = "expensemanager"
+
+// Setup exports spans over OTLP/HTTP to endpoint, such as
+// http://localhost:4318 for a local collector, sampling the given ratio of
+// new traces. An empty endpoint leaves tracing off. The returned function
+// flushes pending spans and must be called before the process exits.
+func Setup(endpoint, serviceName, version string, sampleRatio float64) (func(context.Context) error, error) {
+	// Trace context sent by a caller is honored even when tracing is off,
+	// so it is passed on unchanged
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start begins a span as a child of the one in ctx
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, opts...)
+}
+
+// Fail marks the span as failed with err
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// ExecuteTemplate renders the named template in a span of its own, so time
+// spent rendering shows apart from the queries that gathered the data
+func ExecuteTemplate(ctx context.Context, tmpl *template.Template, w io.Writer, name string, data any) error {
+	_, span := Start(ctx, "template "+name, trace.WithAttributes(attribute.String("template.name", name)))
+	defer span.End()
+
+	err := tmpl.ExecuteTemplate(w, name, data)
+	if err != nil {
+		Fail(span, err)
+	}
+	return err
+}